@@ -0,0 +1,184 @@
+package sib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// An Archive keeps a copy of every email sent by a Client, stored under
+// the Message_id returned by the API. Implementations must be safe for
+// concurrent use.
+type Archive interface {
+	Store(messageID string, message []byte) error
+}
+
+// ArchiveError is returned when an email was sent but could not be
+// archived. The response returned alongside it is valid, so the email
+// must not be sent again.
+type ArchiveError struct {
+	MessageID string
+	Err       error
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("Email %s was sent but could not be archived: %v", e.MessageID, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// WithArchive stores a MIME copy of every successfully sent email in a.
+// Bcc recipients, which a MIME message does not show, are recorded in an
+// X-Archived-Bcc header. Attachments given as URLs are not downloaded
+// again; their URLs are recorded in an X-Archived-Attachment-Urls header.
+// Template emails are archived as rendered with the template fetched just
+// before the send, which costs an extra GetTemplate request per send, or
+// per batch with SendTemplateEmailBatch. WithStrictTemplates checks
+// attributes against that same fetch.
+func WithArchive(a Archive) Option {
+	return func(c *Client) {
+		c.archive = a
+	}
+}
+
+// DirArchive stores each message as <Message_id>.eml in a directory.
+type DirArchive string
+
+var unsafeFileName = regexp.MustCompile(`[^A-Za-z0-9@._+-]+`)
+
+// Store implements Archive. The file is written atomically.
+func (d DirArchive) Store(messageID string, message []byte) error {
+
+	name := unsafeFileName.ReplaceAllString(messageID, "")
+	if name == "" || name[0] == '.' {
+		return fmt.Errorf("Invalid message id %q", messageID)
+	}
+
+	err := os.MkdirAll(string(d), 0755)
+	if err != nil {
+		err = fmt.Errorf("Could not create archive directory: %+v", err)
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(string(d), ".tmp-")
+	if err != nil {
+		err = fmt.Errorf("Could not create archive file: %+v", err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(message)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		err = fmt.Errorf("Could not write archive file: %+v", err)
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), filepath.Join(string(d), name+".eml"))
+	if err != nil {
+		err = fmt.Errorf("Could not write archive file: %+v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) archiveEmail(resp EmailResponse, e *Email) error {
+
+	if c.archive == nil || resp.Code != "success" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	err := archivedCopy(e).writeMIME(&buf, resp.Data.Message_id)
+	if err == nil {
+		err = c.archive.Store(resp.Data.Message_id, buf.Bytes())
+	}
+	if err != nil {
+		return &ArchiveError{MessageID: resp.Data.Message_id, Err: err}
+	}
+
+	return nil
+}
+
+// archivedCopy returns e with its Bcc recipients and URL attachments moved
+// into headers.
+func archivedCopy(e *Email) *Email {
+
+	a := *e
+	a.Headers = copyMap(e.Headers)
+	if a.Headers == nil {
+		a.Headers = make(map[string]string)
+	}
+
+	if len(e.Bcc) > 0 {
+		a.Headers["X-Archived-Bcc"] = formatAddressMap(e.Bcc)
+	}
+
+	var urls []string
+	a.Attachment = make(map[string]string)
+	for _, name := range sortedKeys(e.Attachment) {
+		value := e.Attachment[name]
+		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			urls = append(urls, "<"+value+">")
+			continue
+		}
+		a.Attachment[name] = value
+	}
+	if len(urls) > 0 {
+		a.Headers["X-Archived-Attachment-Urls"] = strings.Join(urls, ", ")
+	}
+
+	return &a
+}
+
+// archivedTemplate fetches template id ahead of a send, so that the
+// archive holds the version that was sent. RawBody is left unchanged.
+func (c *Client) archivedTemplate(id int) (*CampaignData, error) {
+
+	if c.archive == nil {
+		return nil, nil
+	}
+
+	c.rawMu.Lock()
+	raw := c.RawBody
+	c.rawMu.Unlock()
+	resp, err := c.GetTemplate(id)
+	c.setRawBody(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		err := fmt.Errorf("Could not fetch template %d: %s", id, resp.Message)
+		return nil, err
+	}
+
+	return &resp.Data[0], nil
+}
+
+// archiveTemplate archives a template email rendered with t, the template
+// returned by archivedTemplate, or reports terr if fetching it failed.
+func (c *Client) archiveTemplate(resp EmailResponse, t *CampaignData, terr error, email *TemplateEmail) error {
+
+	if c.archive == nil || resp.Code != "success" {
+		return nil
+	}
+	if terr != nil {
+		return &ArchiveError{MessageID: resp.Data.Message_id, Err: terr}
+	}
+
+	r := RenderTemplate(t, email)
+
+	return c.archiveEmail(resp, r.Email())
+}
@@ -0,0 +1,232 @@
+package sib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEmailWriteMIME(t *testing.T) {
+
+	e := NewEmail()
+	e.To["to@example.com"] = "To"
+	e.From = [2]string{"from@example.com", "From"}
+	e.Subject = "Plain"
+	e.Text = "only text"
+
+	var buf bytes.Buffer
+	err := e.WriteMIME(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Single-part emails are being wrapped in multiparts: %s", msg.Header.Get("Content-Type"))
+	}
+	if msg.Header.Get("Message-Id") == "" || msg.Header.Get("Date") == "" {
+		t.Error("Message-Id and Date are not being set.")
+	}
+	b, _ := ioutil.ReadAll(msg.Body)
+	if string(b) != "only text" {
+		t.Errorf("Body is not being written: %q", b)
+	}
+}
+
+func TestEmailWriteMIMEHeaders(t *testing.T) {
+
+	for _, h := range [][2]string{
+		{"Content-Type", "text/html"},
+		{"X-Bad\r\nBcc", "x"},
+		{"X-Tag", "a\r\nBcc: spy@example.com"},
+	} {
+		e := NewEmail()
+		e.From = [2]string{"from@example.com", "From"}
+		e.Headers[h[0]] = h[1]
+		if err := e.WriteMIME(ioutil.Discard); err == nil {
+			t.Errorf("Header %q: %q is not being rejected.", h[0], h[1])
+		}
+	}
+
+	e := NewEmail()
+	e.From = [2]string{"from@example.com", "From"}
+	for i := 0; i < 200; i++ {
+		e.To[fmt.Sprintf("user%d@example.com", i)] = "User"
+	}
+	e.Text = "hi"
+
+	var buf bytes.Buffer
+	if err := e.WriteMIME(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("Long header lines are not being folded: %d characters", len(line))
+		}
+	}
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil || len(to) != 200 {
+		t.Errorf("Folded recipients are not being read back: %d, %v", len(to), err)
+	}
+}
+
+type memArchive map[string][]byte
+
+func (m memArchive) Store(id string, b []byte) error {
+	m[id] = b
+	return nil
+}
+
+func TestClientArchive(t *testing.T) {
+
+	archive := memArchive{}
+	var methods []string
+	client, _ := NewClient("123", WithArchive(archive))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		methods = append(methods, r.Method)
+		if r.Method == "GET" {
+			return jsonResponse(`{"code":"success","data":[{"id":7,"subject":"Hi %FIRSTNAME%","html_content":"<p>%FIRSTNAME%</p>","from_email":"news@example.com","from_name":"News"}]}`), nil
+		}
+		return jsonResponse(`{"code":"success","data":{"message-id":"<42@smtp-relay.mailin.fr>"}}`), nil
+	})
+
+	e := NewEmail()
+	e.To["to@example.com"] = ""
+	e.From = [2]string{"from@example.com", ""}
+	e.HTML = "<p>Hi</p>"
+	e.Bcc["audit@example.com"] = ""
+	e.Attachment["report.pdf"] = "https://example.com/report.pdf"
+	_, err := client.SendEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(archive["<42@smtp-relay.mailin.fr>"]))
+	if err != nil {
+		t.Fatal("Sent emails are not being archived under their message id.")
+	}
+	if msg.Header.Get("Message-Id") != "<42@smtp-relay.mailin.fr>" {
+		t.Errorf("Archived copy does not carry the API message id: %s", msg.Header.Get("Message-Id"))
+	}
+	if msg.Header.Get("X-Archived-Bcc") != "<audit@example.com>" {
+		t.Errorf("Bcc recipients are not being archived: %v", msg.Header)
+	}
+	if msg.Header.Get("X-Archived-Attachment-Urls") != "<https://example.com/report.pdf>" {
+		t.Errorf("URL attachments are not being recorded: %v", msg.Header)
+	}
+
+	methods = nil
+
+	delete(archive, "<42@smtp-relay.mailin.fr>")
+	opts := NewEmailOptions("", "", nil, nil)
+	opts.Attr["FIRSTNAME"] = "Ada"
+	_, err = client.SendTemplateEmail(7, []string{"ada@example.com"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(client.RawBody), "message-id") {
+		t.Error("RawBody is not holding the send response.")
+	}
+	if strings.Join(methods, ",") != "GET,PUT" {
+		t.Errorf("The template is not being fetched before the send: %v", methods)
+	}
+
+	msg, err = mail.ReadMessage(bytes.NewReader(archive["<42@smtp-relay.mailin.fr>"]))
+	if err != nil {
+		t.Fatal("Template emails are not being archived.")
+	}
+	b, _ := ioutil.ReadAll(msg.Body)
+	if msg.Header.Get("Subject") != "Hi Ada" || !strings.Contains(string(b), "<p>Ada</p>") {
+		t.Errorf("Template emails are not being archived as rendered: %s %q", msg.Header.Get("Subject"), b)
+	}
+	if msg.Header.Get("From") != `"News" <news@example.com>` || msg.Header.Get("To") != "<ada@example.com>" {
+		t.Errorf("Template sender and recipients are not being archived: %v", msg.Header)
+	}
+}
+
+type failingArchive struct{}
+
+func (failingArchive) Store(string, []byte) error { return errors.New("disk full") }
+
+func TestClientArchiveTemplateFetches(t *testing.T) {
+
+	var gets int32
+	client, _ := NewClient("123", WithArchive(memArchive{}), WithStrictTemplates(nil))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+			return jsonResponse(`{"code":"success","data":[{"id":7,"subject":"Hi %FIRSTNAME%","html_content":"<p>%FIRSTNAME%</p>","from_email":"news@example.com","from_name":"News"}]}`), nil
+		}
+		return jsonResponse(`{"code":"success","data":{"message-id":"<42@smtp-relay.mailin.fr>"}}`), nil
+	})
+
+	opts := &EmailOptions{Attr: map[string]string{"FIRSTNAME": "Ada"}}
+	if _, err := client.SendTemplateEmail(7, []string{"ada@example.com"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.SwapInt32(&gets, 0); n != 1 {
+		t.Errorf("The strict check is not reusing the archive's fetch: %d fetches", n)
+	}
+
+	recipients := []TemplateRecipient{{To: "a@example.com"}, {To: "b@example.com"}, {To: "c@example.com"}}
+	for _, r := range client.SendTemplateEmailBatch(7, recipients, opts, 1) {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Errorf("Batches are fetching the template per recipient: %d fetches", n)
+	}
+}
+
+func TestClientArchiveFailure(t *testing.T) {
+
+	client, _ := NewClient("123", WithArchive(failingArchive{}))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return jsonResponse(`{"code":"success","data":{"message-id":"<42@smtp-relay.mailin.fr>"}}`), nil
+	})
+
+	e := NewEmail()
+	e.To["to@example.com"] = ""
+	resp, err := client.SendEmail(e)
+
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) || archiveErr.MessageID != "<42@smtp-relay.mailin.fr>" {
+		t.Errorf("Archive failures are not being reported: %v", err)
+	}
+	if resp.Code != "success" {
+		t.Error("The send response is not being returned with archive failures.")
+	}
+}
+
+func TestDirArchive(t *testing.T) {
+
+	dir := t.TempDir()
+	err := DirArchive(dir).Store("<42@smtp-relay.mailin.fr>", []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "42@smtp-relay.mailin.fr.eml"))
+	if err != nil || string(b) != "message" {
+		t.Errorf("Message is not being stored: %q %v", b, err)
+	}
+
+	if DirArchive(dir).Store("../..", []byte("x")) == nil {
+		t.Error("Unsafe message ids are being accepted.")
+	}
+}
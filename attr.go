@@ -0,0 +1,294 @@
+package sib
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AttrTimeFormat is the layout used for time.Time attributes,
+// matching the date format of SendInBlue contact attributes.
+var AttrTimeFormat = "2006-01-02"
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// AttrError reports struct fields or attribute names that could not be
+// mapped by EncodeAttr or DecodeAttr.
+type AttrError struct {
+	Problems []string
+}
+
+func (e *AttrError) Error() string {
+	return "Attribute codec: " + strings.Join(e.Problems, "; ")
+}
+
+// EncodeAttr converts a struct into a template or contact attribute map.
+// Fields are mapped through their `sib` tag:
+//
+//	type Welcome struct {
+//		FirstName string    `sib:"FIRSTNAME"`
+//		Credits   int       `sib:"CREDITS"`
+//		Renewal   time.Time `sib:"RENEWAL,omitempty"`
+//		Internal  string    `sib:"-"`
+//	}
+//
+// Numbers and booleans use strconv formatting, time.Time uses
+// AttrTimeFormat and encoding.TextMarshaler values are marshalled.
+// Nil pointers and, with omitempty, zero values are left out. Embedded
+// structs are flattened. Exported fields without a tag and unexported
+// fields with one are reported as an *AttrError.
+func EncodeAttr(v interface{}) (map[string]string, error) {
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("Attribute codec: cannot encode nil %T", v)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Attribute codec: cannot encode %T, need a struct", v)
+	}
+
+	attr := make(map[string]string)
+	var problems []string
+
+	encodeStruct(rv, attr, &problems)
+
+	if len(problems) > 0 {
+		return attr, &AttrError{Problems: problems}
+	}
+	return attr, nil
+}
+
+// DecodeAttr fills the `sib`-tagged fields of the struct pointed to by v
+// from an attribute map. It is the inverse of EncodeAttr; attribute
+// names without a matching field are reported as an *AttrError after
+// every known attribute has been decoded.
+func DecodeAttr(attr map[string]string, v interface{}) error {
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Attribute codec: cannot decode into %T, need a pointer to a struct", v)
+	}
+
+	fields := make(map[string]reflect.Value)
+	var problems []string
+	collectFields(rv.Elem(), fields, &problems)
+
+	var names []string
+	for name := range attr {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f, ok := fields[strings.ToUpper(name)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown attribute %s", name))
+			continue
+		}
+		if err := decodeValue(f, attr[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("attribute %s: %v", name, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &AttrError{Problems: problems}
+	}
+	return nil
+}
+
+// SetAttr encodes a tagged struct with EncodeAttr and merges the result
+// into the options' Attr map.
+func (e *EmailOptions) SetAttr(v interface{}) error {
+
+	attr, err := EncodeAttr(v)
+	if err != nil {
+		return err
+	}
+
+	if e.Attr == nil {
+		e.Attr = make(map[string]string)
+	}
+	for k, val := range attr {
+		e.Attr[k] = val
+	}
+
+	return nil
+}
+
+func parseAttrTag(f reflect.StructField) (name string, omitempty, tagged bool) {
+
+	tag, ok := f.Tag.Lookup("sib")
+	if !ok {
+		return "", false, false
+	}
+
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+
+	return parts[0], omitempty, true
+}
+
+func encodeStruct(rv reflect.Value, attr map[string]string, problems *[]string) {
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name, omitempty, tagged := parseAttrTag(f)
+
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			encodeStruct(rv.Field(i), attr, problems)
+			continue
+		}
+		if name == "-" {
+			continue
+		}
+		if f.PkgPath != "" {
+			if tagged {
+				*problems = append(*problems, fmt.Sprintf("field %s.%s is unexported", rt.Name(), f.Name))
+			}
+			continue
+		}
+		if !tagged || name == "" {
+			*problems = append(*problems, fmt.Sprintf("field %s.%s has no sib tag", rt.Name(), f.Name))
+			continue
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if omitempty && fv.IsZero() {
+			continue
+		}
+
+		s, err := encodeValue(fv)
+		if err != nil {
+			*problems = append(*problems, fmt.Sprintf("field %s.%s: %v", rt.Name(), f.Name, err))
+			continue
+		}
+		attr[name] = s
+	}
+}
+
+func encodeValue(v reflect.Value) (string, error) {
+
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(AttrTimeFormat), nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	}
+
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func collectFields(rv reflect.Value, fields map[string]reflect.Value, problems *[]string) {
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name, _, tagged := parseAttrTag(f)
+
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			collectFields(rv.Field(i), fields, problems)
+			continue
+		}
+		if !tagged || name == "" || name == "-" {
+			continue
+		}
+		if f.PkgPath != "" {
+			*problems = append(*problems, fmt.Sprintf("field %s.%s is unexported", rt.Name(), f.Name))
+			continue
+		}
+
+		fields[strings.ToUpper(name)] = rv.Field(i)
+	}
+}
+
+func decodeValue(v reflect.Value, s string) error {
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == timeType {
+		t, err := time.Parse(AttrTimeFormat, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
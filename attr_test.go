@@ -0,0 +1,128 @@
+package sib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testBase struct {
+	Email string `sib:"EMAIL"`
+}
+
+type testAttr struct {
+	testBase
+	FirstName string    `sib:"FIRSTNAME"`
+	Credits   int       `sib:"CREDITS"`
+	Balance   float64   `sib:"BALANCE"`
+	Pro       bool      `sib:"PRO"`
+	Renewal   time.Time `sib:"RENEWAL"`
+	Nickname  *string   `sib:"NICKNAME"`
+	Plan      string    `sib:"PLAN,omitempty"`
+	Internal  string    `sib:"-"`
+}
+
+func TestEncodeAttr(t *testing.T) {
+
+	v := testAttr{
+		testBase:  testBase{Email: "ada@example.net"},
+		FirstName: "Ada",
+		Credits:   42,
+		Balance:   12.5,
+		Pro:       true,
+		Renewal:   time.Date(2018, 3, 9, 15, 4, 5, 0, time.UTC),
+		Internal:  "secret",
+	}
+
+	attr, err := EncodeAttr(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"EMAIL":     "ada@example.net",
+		"FIRSTNAME": "Ada",
+		"CREDITS":   "42",
+		"BALANCE":   "12.5",
+		"PRO":       "true",
+		"RENEWAL":   "2018-03-09",
+	}
+	if !reflect.DeepEqual(attr, want) {
+		t.Errorf("Expected %v, got %v", want, attr)
+	}
+}
+
+func TestEncodeAttrReportsFields(t *testing.T) {
+
+	v := struct {
+		Name    string `sib:"NAME"`
+		Missing string
+		secret  string `sib:"SECRET"`
+		List    []int  `sib:"LIST"`
+	}{}
+
+	_, err := EncodeAttr(v)
+	e, ok := err.(*AttrError)
+	if !ok {
+		t.Fatalf("Expected an AttrError, got %v", err)
+	}
+	if len(e.Problems) != 3 {
+		t.Errorf("Expected 3 problems, got %v", e.Problems)
+	}
+	for _, want := range []string{"Missing", "secret", "List"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %s to be reported: %v", want, err)
+		}
+	}
+
+	if _, err := EncodeAttr("nope"); err == nil {
+		t.Error("Expected EncodeAttr to reject a non-struct.")
+	}
+}
+
+func TestDecodeAttr(t *testing.T) {
+
+	var v testAttr
+	err := DecodeAttr(map[string]string{
+		"email":     "ada@example.net",
+		"FIRSTNAME": "Ada",
+		"CREDITS":   "42",
+		"PRO":       "true",
+		"RENEWAL":   "2018-03-09",
+		"NICKNAME":  "countess",
+		"SHOE_SIZE": "38",
+	}, &v)
+
+	e, ok := err.(*AttrError)
+	if !ok || len(e.Problems) != 1 || !strings.Contains(e.Problems[0], "SHOE_SIZE") {
+		t.Errorf("Expected only SHOE_SIZE to be reported, got %v", err)
+	}
+
+	if v.Email != "ada@example.net" || v.FirstName != "Ada" || v.Credits != 42 || !v.Pro {
+		t.Errorf("Attributes are not being decoded: %+v", v)
+	}
+	if !v.Renewal.Equal(time.Date(2018, 3, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Dates are not being decoded: %v", v.Renewal)
+	}
+	if v.Nickname == nil || *v.Nickname != "countess" {
+		t.Error("Pointer fields are not being decoded.")
+	}
+}
+
+func TestEmailOptionsSetAttr(t *testing.T) {
+
+	options := NewEmailOptions("", "", nil, nil)
+	options.Attr["EXISTING"] = "kept"
+
+	err := options.SetAttr(struct {
+		FirstName string `sib:"FIRSTNAME"`
+	}{"Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.Attr["FIRSTNAME"] != "Ada" || options.Attr["EXISTING"] != "kept" {
+		t.Errorf("Attributes are not being merged: %v", options.Attr)
+	}
+}
@@ -0,0 +1,92 @@
+package sib
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultBatchWorkers is the number of concurrent requests used by
+// SendTemplateEmailBatch when no worker count is given.
+var DefaultBatchWorkers = 4
+
+// TemplateRecipient is a single recipient of a batch template send,
+// with the attributes personalised for them.
+type TemplateRecipient struct {
+	To   string
+	Attr map[string]string
+}
+
+// TemplateResult is the outcome of sending to one TemplateRecipient.
+// Response.Data.Message_id is set on success, Err on failure.
+type TemplateResult struct {
+	To       string
+	Response EmailResponse
+	Err      error
+}
+
+// SendTemplateEmailBatch sends template id to every recipient as a
+// separate message, so nobody sees the other recipients and each gets
+// their own attributes. Recipient attributes are merged over e.Attr,
+// while the other options in e are shared. At most workers requests run
+// at once (DefaultBatchWorkers if workers < 1). Results are returned in
+// the order of recipients.
+func (c *Client) SendTemplateEmailBatch(id int, recipients []TemplateRecipient, e *EmailOptions, workers int) []TemplateResult {
+
+	if workers < 1 {
+		workers = DefaultBatchWorkers
+	}
+	if workers > len(recipients) {
+		workers = len(recipients)
+	}
+
+	// one fetch of the template serves the archive for every recipient
+	template, terr := c.archivedTemplate(id)
+
+	results := make([]TemplateResult, len(recipients))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = c.sendToRecipient(id, recipients[i], e, template, terr)
+			}
+		}()
+	}
+
+	for i := range recipients {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (c *Client) sendToRecipient(id int, r TemplateRecipient, e *EmailOptions, template *CampaignData, terr error) TemplateResult {
+
+	options := &EmailOptions{}
+	if e != nil {
+		*options = *e
+	}
+
+	options.Attr = make(map[string]string)
+	if e != nil {
+		for k, v := range e.Attr {
+			options.Attr[k] = v
+		}
+	}
+	for k, v := range r.Attr {
+		options.Attr[k] = v
+	}
+
+	result := TemplateResult{To: r.To}
+	result.Response, result.Err = c.sendTemplateEmail(id, []string{r.To}, options, template, terr)
+	if result.Err == nil && result.Response.Code != "success" {
+		result.Err = fmt.Errorf("Request error: %s", result.Response.Message)
+	}
+
+	return result
+}
@@ -0,0 +1,81 @@
+package sib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendTemplateEmailBatch(t *testing.T) {
+
+	client, _ := NewClient("123")
+
+	var inFlight, maxInFlight int32
+	var mu sync.Mutex
+	sent := make(map[string]TemplateEmail)
+
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		var email TemplateEmail
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &email)
+
+		mu.Lock()
+		sent[email.To] = email
+		mu.Unlock()
+
+		if email.To == "bounce@example.net" {
+			return jsonResponse(`{"code":"failure","message":"invalid recipient"}`), nil
+		}
+		return jsonResponse(fmt.Sprintf(`{"code":"success","data":{"message-id":"<%s>"}}`, email.To)), nil
+	})
+
+	var recipients []TemplateRecipient
+	for i := 0; i < 8; i++ {
+		to := fmt.Sprintf("user%d@example.net", i)
+		recipients = append(recipients, TemplateRecipient{To: to, Attr: map[string]string{"NAME": to}})
+	}
+	recipients = append(recipients, TemplateRecipient{To: "bounce@example.net"})
+
+	options := NewEmailOptions("", "", nil, nil)
+	options.Attr["SHARED"] = "yes"
+
+	results := client.SendTemplateEmailBatch(7, recipients, options, 3)
+
+	if len(results) != len(recipients) {
+		t.Fatalf("Expected %d results, got %d", len(recipients), len(results))
+	}
+	for i, r := range results[:8] {
+		if r.To != recipients[i].To || r.Err != nil || r.Response.Data.Message_id != "<"+r.To+">" {
+			t.Errorf("Unexpected result for %s: %+v", recipients[i].To, r)
+		}
+		email := sent[r.To]
+		if email.Attr["NAME"] != r.To || email.Attr["SHARED"] != "yes" {
+			t.Errorf("Attributes are not personalised for %s: %v", r.To, email.Attr)
+		}
+	}
+	if results[8].Err == nil {
+		t.Error("Expected an API failure to be reported per recipient.")
+	}
+
+	if maxInFlight > 3 {
+		t.Errorf("Expected at most 3 concurrent requests, saw %d", maxInFlight)
+	}
+	if len(options.Attr) != 1 {
+		t.Errorf("Shared options are being modified: %v", options.Attr)
+	}
+}
@@ -0,0 +1,218 @@
+package sib
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // requests flow normally
+	BreakerOpen                         // requests fail fast
+	BreakerHalfOpen                     // probe requests test recovery
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// CircuitOpenError is returned, wrapped, by Client methods while the
+// circuit breaker is open. Use errors.As to detect it.
+type CircuitOpenError struct {
+	RetryAt time.Time // when the breaker will let a probe through
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Circuit breaker is open until %s", e.RetryAt.Format(time.RFC3339))
+}
+
+// BreakerConfig configures WithCircuitBreaker. Zero fields get defaults.
+type BreakerConfig struct {
+	FailureRatio   float64       // open when failures/requests reaches this (default 0.5)
+	MinRequests    int           // requests in a window before the ratio applies (default 10)
+	Window         time.Duration // failure counts are reset every Window (default 1m)
+	OpenTimeout    time.Duration // time spent open before probing (default 30s)
+	HalfOpenProbes int           // successful probes needed to close (default 1)
+
+	// OnStateChange, if set, is called on every transition. It must not
+	// call back into the Client.
+	OnStateChange func(from, to BreakerState)
+}
+
+// WithCircuitBreaker puts a circuit breaker in front of the Client's
+// requests. Transport errors (including timeouts) and 5xx responses count as
+// failures. Once the failure ratio is reached the breaker opens and
+// requests fail at once with a *CircuitOpenError; after OpenTimeout it
+// lets probe requests through and closes again when they succeed.
+//
+// The breaker is checked on every request the Client makes, so it stays
+// in force when the http.Client or its Transport is replaced.
+func WithCircuitBreaker(cfg BreakerConfig) Option {
+	return func(c *Client) {
+		c.breaker = newBreaker(cfg)
+	}
+}
+
+// BreakerState returns the state of the Client's circuit breaker, or
+// BreakerClosed when it has none.
+func (c *Client) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+	return c.breaker.currentState()
+}
+
+type breaker struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int // probes in flight while half-open
+	successes   int // successful probes while half-open
+}
+
+func newBreaker(cfg BreakerConfig) *breaker {
+
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+
+	return &breaker{cfg: cfg, now: time.Now, windowStart: time.Now()}
+}
+
+func (b *breaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a request may be sent and whether it is a probe.
+func (b *breaker) allow() (bool, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	switch b.state {
+	case BreakerOpen:
+		retryAt := b.openedAt.Add(b.cfg.OpenTimeout)
+		if now.Before(retryAt) {
+			return false, &CircuitOpenError{RetryAt: retryAt}
+		}
+		b.setState(BreakerHalfOpen)
+		b.probes, b.successes = 0, 0
+		fallthrough
+
+	case BreakerHalfOpen:
+		if b.probes+b.successes >= b.cfg.HalfOpenProbes {
+			return false, &CircuitOpenError{RetryAt: now}
+		}
+		b.probes++
+		return true, nil
+	}
+
+	if now.Sub(b.windowStart) >= b.cfg.Window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+
+	return false, nil
+}
+
+func (b *breaker) done(probe, failed bool) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		if b.state != BreakerHalfOpen {
+			return
+		}
+		b.probes--
+		if failed {
+			b.trip()
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenProbes {
+			b.setState(BreakerClosed)
+			b.windowStart, b.requests, b.failures = b.now(), 0, 0
+		}
+		return
+	}
+
+	if b.state != BreakerClosed {
+		return
+	}
+
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.cfg.MinRequests && float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio {
+		b.trip()
+	}
+}
+
+func (b *breaker) trip() {
+	b.openedAt = b.now()
+	b.setState(BreakerOpen)
+}
+
+func (b *breaker) setState(s BreakerState) {
+	if s == b.state {
+		return
+	}
+	from := b.state
+	b.state = s
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, s)
+	}
+}
+
+// do sends req with client unless the breaker is open, and records the
+// outcome.
+func (b *breaker) do(client *http.Client, req *http.Request) (*http.Response, error) {
+
+	probe, err := b.allow()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	b.done(probe, err != nil || resp.StatusCode >= 500)
+
+	return resp, err
+}
@@ -0,0 +1,116 @@
+package sib
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	var transitions []string
+	client, _ := NewClient("123")
+
+	failing := true
+	calls := 0
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if failing {
+			return nil, errors.New("connection refused")
+		}
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	WithCircuitBreaker(BreakerConfig{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		OpenTimeout:  time.Minute,
+		OnStateChange: func(from, to BreakerState) {
+			transitions = append(transitions, from.String()+">"+to.String())
+		},
+	})(client)
+
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		client.SendSMS(&SMSRequest{})
+	}
+	if client.BreakerState() != BreakerOpen {
+		t.Fatalf("Expected the breaker to open after 4 failures, got %s", client.BreakerState())
+	}
+
+	_, err := client.SendSMS(&SMSRequest{})
+	var open *CircuitOpenError
+	if !errors.As(err, &open) {
+		t.Fatalf("Expected a CircuitOpenError, got %v", err)
+	}
+	if !open.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Unexpected retry time: %v", open.RetryAt)
+	}
+	if calls != 4 {
+		t.Errorf("Expected the open breaker to fail fast, made %d calls", calls)
+	}
+
+	// a failed probe opens the breaker again
+	now = now.Add(time.Minute)
+	client.SendSMS(&SMSRequest{})
+	if client.BreakerState() != BreakerOpen || calls != 5 {
+		t.Errorf("Expected a failed probe to reopen the breaker: %s, %d calls", client.BreakerState(), calls)
+	}
+
+	// a successful probe closes it
+	failing = false
+	now = now.Add(time.Minute)
+	if _, err := client.SendSMS(&SMSRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if client.BreakerState() != BreakerClosed {
+		t.Errorf("Expected a successful probe to close the breaker, got %s", client.BreakerState())
+	}
+
+	want := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("Expected transitions %v, got %v", want, transitions)
+			break
+		}
+	}
+}
+
+func TestCircuitBreakerCountsServerErrors(t *testing.T) {
+
+	client, _ := NewClient("123")
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp := jsonResponse(`{"code":"failure"}`)
+		resp.StatusCode = 503
+		return resp, nil
+	})
+	WithCircuitBreaker(BreakerConfig{MinRequests: 2})(client)
+
+	client.SendSMS(&SMSRequest{})
+	client.SendSMS(&SMSRequest{})
+	if client.BreakerState() != BreakerOpen {
+		t.Errorf("Expected 5xx responses to open the breaker, got %s", client.BreakerState())
+	}
+}
+
+func TestCircuitBreakerSurvivesTransportChange(t *testing.T) {
+
+	client, _ := NewClient("123", WithCircuitBreaker(BreakerConfig{MinRequests: 1}))
+	calls := 0
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("connection refused")
+	})
+
+	client.SendSMS(&SMSRequest{})
+	client.SendSMS(&SMSRequest{})
+	if client.BreakerState() != BreakerOpen || calls != 1 {
+		t.Errorf("The circuit breaker is not being kept when the transport changes: %s, %d calls", client.BreakerState(), calls)
+	}
+}
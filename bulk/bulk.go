@@ -0,0 +1,383 @@
+// Package bulk sends a template email or an SMS to every row of a CSV
+// file, with bounded concurrency, rate limiting, a results CSV and a
+// checkpoint file that lets an interrupted run resume without sending
+// any row twice.
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+// Sender is the subset of *sib.Client used for bulk sends.
+type Sender interface {
+	SendTemplateEmail(id int, to []string, e *sib.EmailOptions) (sib.EmailResponse, error)
+	SendSMS(s *sib.SMSRequest) (sib.SMSResponse, error)
+}
+
+// Job describes how CSV rows are turned into sends. Column names are
+// matched against the CSV header case-insensitively.
+type Job struct {
+	// TemplateID selects a template email send. When it is 0, an SMS is
+	// sent for every row instead.
+	TemplateID int
+	Options    *sib.EmailOptions // options shared by every template email
+
+	// SMS holds the fields shared by every SMS. %COLUMN% placeholders in
+	// its Text are replaced by the row's value of that column.
+	SMS sib.SMSRequest
+
+	To        string            // column holding the email address or mobile number
+	Key       string            // column identifying a row in the checkpoint (default To)
+	Attr      map[string]string // template attribute -> column; nil maps every other column by its header
+	SMSFields map[string]string // SMSRequest field (from, text, tag, web_url, type) -> column
+
+	Workers    int     // concurrent sends (default 1)
+	Rate       float64 // maximum sends per second across workers, 0 for no limit
+	Checkpoint string  // checkpoint file path, empty to disable resuming
+}
+
+// Summary counts the outcome of a Run.
+type Summary struct {
+	Rows    int
+	Sent    int
+	Failed  int
+	Skipped int // already sent by an earlier run, or a duplicate key
+}
+
+// Result statuses written to the results CSV.
+const (
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+var smsFields = map[string]func(*sib.SMSRequest, string){
+	"to":      func(s *sib.SMSRequest, v string) { s.To = v },
+	"from":    func(s *sib.SMSRequest, v string) { s.From = v },
+	"text":    func(s *sib.SMSRequest, v string) { s.Text = v },
+	"web_url": func(s *sib.SMSRequest, v string) { s.Web_url = v },
+	"tag":     func(s *sib.SMSRequest, v string) { s.Tag = v },
+	"type":    func(s *sib.SMSRequest, v string) { s.Type = v },
+}
+
+var columnPlaceholder = regexp.MustCompile(`%([A-Za-z0-9_]+)%`)
+
+type row struct {
+	n      int
+	key    string
+	fields []string
+}
+
+type outcome struct {
+	row       row
+	to        string
+	status    string
+	messageID string
+	err       error
+}
+
+// Run streams rows from in and sends one message per row. A results CSV
+// with the columns row, key, to, status, message_id and error is written
+// to results. Rows whose key is in the checkpoint are skipped and
+// reported with the message-id of the earlier send; rows with an empty
+// key fail, since they cannot be resumed. Run stops reading
+// when ctx is cancelled and waits for sends in flight.
+func Run(ctx context.Context, s Sender, in io.Reader, results io.Writer, job Job) (Summary, error) {
+
+	var sum Summary
+
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		err = fmt.Errorf("Could not read CSV header: %+v", err)
+		return sum, err
+	}
+
+	m, err := newMapper(header, job)
+	if err != nil {
+		return sum, err
+	}
+
+	cp, err := openCheckpoint(job.Checkpoint)
+	if err != nil {
+		return sum, err
+	}
+	defer cp.close()
+
+	w := csv.NewWriter(results)
+	w.Write([]string{"row", "key", "to", "status", "message_id", "error"})
+
+	workers := job.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var tick <-chan time.Time
+	if job.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / job.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	rows := make(chan row)
+	outcomes := make(chan outcome)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rw := range rows {
+				if tick != nil {
+					<-tick
+				}
+				outcomes <- m.send(s, cp, rw)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for o := range outcomes {
+			errText := ""
+			if o.err != nil {
+				errText = o.err.Error()
+			}
+			switch o.status {
+			case StatusSent:
+				sum.Sent++
+			case StatusSkipped:
+				sum.Skipped++
+			case StatusFailed:
+				sum.Failed++
+			}
+			w.Write([]string{strconv.Itoa(o.row.n), o.row.key, o.to, o.status, o.messageID, errText})
+			w.Flush()
+		}
+		close(done)
+	}()
+
+	seen := make(map[string]bool)
+	var runErr error
+
+feed:
+	for n := 1; ; n++ {
+		select {
+		case <-ctx.Done():
+			runErr = ctx.Err()
+			break feed
+		default:
+		}
+
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			runErr = fmt.Errorf("Could not read CSV row %d: %+v", n, err)
+			break
+		}
+		sum.Rows++
+
+		rw := row{n: n, key: m.key(fields), fields: fields}
+
+		if rw.key == "" {
+			outcomes <- outcome{row: rw, to: m.to(fields), status: StatusFailed, err: fmt.Errorf("Row has an empty key")}
+			continue
+		}
+		if id, ok := cp.lookup(rw.key); ok {
+			outcomes <- outcome{row: rw, to: m.to(fields), status: StatusSkipped, messageID: id}
+			continue
+		}
+		if seen[rw.key] {
+			outcomes <- outcome{row: rw, to: m.to(fields), status: StatusSkipped}
+			continue
+		}
+		seen[rw.key] = true
+
+		select {
+		case rows <- rw:
+		case <-ctx.Done():
+			runErr = ctx.Err()
+			break feed
+		}
+	}
+
+	close(rows)
+	wg.Wait()
+	close(outcomes)
+	<-done
+
+	if err := w.Error(); err != nil && runErr == nil {
+		runErr = fmt.Errorf("Could not write results: %+v", err)
+	}
+
+	return sum, runErr
+}
+
+// mapper turns CSV rows into sends according to a Job.
+type mapper struct {
+	job     Job
+	columns map[string]int
+	toCol   int
+	keyCol  int
+	attr    map[string]int
+	sms     map[string]int
+}
+
+func newMapper(header []string, job Job) (*mapper, error) {
+
+	m := &mapper{
+		job:     job,
+		columns: make(map[string]int),
+		attr:    make(map[string]int),
+		sms:     make(map[string]int),
+	}
+	for i, h := range header {
+		m.columns[strings.ToUpper(strings.TrimSpace(h))] = i
+	}
+
+	col := func(name string) (int, error) {
+		i, ok := m.columns[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("CSV has no column %q", name)
+		}
+		return i, nil
+	}
+
+	var err error
+	if m.toCol, err = col(job.To); err != nil {
+		return nil, err
+	}
+	m.keyCol = m.toCol
+	if job.Key != "" {
+		if m.keyCol, err = col(job.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if job.TemplateID != 0 {
+		if job.Attr == nil {
+			for i, h := range header {
+				if i != m.toCol {
+					m.attr[strings.ToUpper(strings.TrimSpace(h))] = i
+				}
+			}
+		}
+		for name, c := range job.Attr {
+			if m.attr[name], err = col(c); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	for field, c := range job.SMSFields {
+		if _, ok := smsFields[field]; !ok {
+			return nil, fmt.Errorf("Unknown SMS field %q", field)
+		}
+		if m.sms[field], err = col(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *mapper) value(fields []string, i int) string {
+	if i < len(fields) {
+		return strings.TrimSpace(fields[i])
+	}
+	return ""
+}
+
+func (m *mapper) to(fields []string) string  { return m.value(fields, m.toCol) }
+func (m *mapper) key(fields []string) string { return m.value(fields, m.keyCol) }
+
+func (m *mapper) send(s Sender, cp *checkpoint, rw row) outcome {
+
+	o := outcome{row: rw, to: m.to(rw.fields), status: StatusFailed}
+
+	if o.to == "" {
+		o.err = fmt.Errorf("Row has no recipient")
+		return o
+	}
+
+	var code, message string
+	if m.job.TemplateID != 0 {
+		resp, err := s.SendTemplateEmail(m.job.TemplateID, []string{o.to}, m.options(rw.fields))
+		if err != nil {
+			o.err = err
+			return o
+		}
+		code, message, o.messageID = resp.Code, resp.Message, resp.Data.Message_id
+	} else {
+		resp, err := s.SendSMS(m.smsRequest(rw.fields))
+		if err != nil {
+			o.err = err
+			return o
+		}
+		code, message, o.messageID = resp.Code, resp.Message, resp.Data.Reference.One
+	}
+
+	if code != "success" {
+		o.err = fmt.Errorf("Request error: %s", message)
+		return o
+	}
+
+	o.status = StatusSent
+	if err := cp.record(rw.key, o.messageID); err != nil {
+		o.err = err
+	}
+
+	return o
+}
+
+func (m *mapper) options(fields []string) *sib.EmailOptions {
+
+	options := &sib.EmailOptions{}
+	if m.job.Options != nil {
+		*options = *m.job.Options
+	}
+
+	options.Attr = make(map[string]string)
+	if m.job.Options != nil {
+		for k, v := range m.job.Options.Attr {
+			options.Attr[k] = v
+		}
+	}
+	for name, i := range m.attr {
+		options.Attr[name] = m.value(fields, i)
+	}
+
+	return options
+}
+
+func (m *mapper) smsRequest(fields []string) *sib.SMSRequest {
+
+	req := m.job.SMS
+	req.To = m.to(fields)
+	for field, i := range m.sms {
+		smsFields[field](&req, m.value(fields, i))
+	}
+
+	req.Text = columnPlaceholder.ReplaceAllStringFunc(req.Text, func(p string) string {
+		if i, ok := m.columns[strings.ToUpper(p[1:len(p)-1])]; ok {
+			return m.value(fields, i)
+		}
+		return p
+	})
+
+	return &req
+}
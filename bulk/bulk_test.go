@@ -0,0 +1,209 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type fakeSender struct {
+	mu     sync.Mutex
+	emails map[string]*sib.EmailOptions
+	sms    []sib.SMSRequest
+	fail   map[string]bool
+}
+
+func newFakeSender() *fakeSender {
+	return &fakeSender{emails: make(map[string]*sib.EmailOptions), fail: make(map[string]bool)}
+}
+
+func (f *fakeSender) SendTemplateEmail(id int, to []string, e *sib.EmailOptions) (sib.EmailResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail[to[0]] {
+		return sib.EmailResponse{Code: "failure", Message: "invalid recipient"}, nil
+	}
+	f.emails[to[0]] = e
+	return sib.EmailResponse{Code: "success", Data: sib.EmailData{Message_id: "<" + to[0] + ">"}}, nil
+}
+
+func (f *fakeSender) SendSMS(s *sib.SMSRequest) (sib.SMSResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sms = append(f.sms, *s)
+	resp := sib.SMSResponse{Code: "success"}
+	resp.Data.Reference.One = fmt.Sprintf("ref-%d", len(f.sms))
+	return resp, nil
+}
+
+const recipients = `email,first_name,plan
+ada@example.net,Ada,pro
+bob@example.net,Bob,free
+bad@example.net,Bad,free
+ada@example.net,Ada again,pro
+`
+
+func readResults(t *testing.T, b []byte) map[string][]string {
+	recs, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byRow := make(map[string][]string)
+	for _, rec := range recs[1:] {
+		byRow[rec[0]] = rec
+	}
+	return byRow
+}
+
+func TestRunTemplateEmail(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "bulk")
+	defer os.RemoveAll(dir)
+
+	s := newFakeSender()
+	s.fail["bad@example.net"] = true
+
+	job := Job{
+		TemplateID: 7,
+		Options:    &sib.EmailOptions{ReplyTo: "support@example.net", Attr: map[string]string{"SHARED": "yes"}},
+		To:         "Email",
+		Attr:       map[string]string{"FIRSTNAME": "first_name"},
+		Workers:    3,
+		Rate:       1000,
+		Checkpoint: filepath.Join(dir, "checkpoint.csv"),
+	}
+
+	var results bytes.Buffer
+	sum, err := Run(context.Background(), s, strings.NewReader(recipients), &results, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum != (Summary{Rows: 4, Sent: 2, Failed: 1, Skipped: 1}) {
+		t.Errorf("Unexpected summary: %+v", sum)
+	}
+
+	ada := s.emails["ada@example.net"]
+	if ada == nil || ada.Attr["FIRSTNAME"] != "Ada" || ada.Attr["SHARED"] != "yes" || ada.ReplyTo != "support@example.net" {
+		t.Errorf("Columns are not being mapped onto attributes: %+v", ada)
+	}
+	if _, ok := ada.Attr["PLAN"]; ok {
+		t.Error("Unmapped columns are being sent.")
+	}
+
+	rows := readResults(t, results.Bytes())
+	if rows["1"][3] != StatusSent || rows["1"][4] != "<ada@example.net>" {
+		t.Errorf("Unexpected result for row 1: %v", rows["1"])
+	}
+	if rows["3"][3] != StatusFailed || rows["3"][5] == "" {
+		t.Errorf("Unexpected result for row 3: %v", rows["3"])
+	}
+	if rows["4"][3] != StatusSkipped {
+		t.Errorf("Expected the duplicate row to be skipped: %v", rows["4"])
+	}
+
+	// resuming sends only the row that failed
+	delete(s.fail, "bad@example.net")
+	s.emails = make(map[string]*sib.EmailOptions)
+	results.Reset()
+
+	sum, err = Run(context.Background(), s, strings.NewReader(recipients), &results, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Sent != 1 || sum.Skipped != 3 || len(s.emails) != 1 || s.emails["bad@example.net"] == nil {
+		t.Errorf("Expected only the failed row to be resent: %+v %v", sum, s.emails)
+	}
+
+	rows = readResults(t, results.Bytes())
+	if rows["2"][3] != StatusSkipped || rows["2"][4] != "<bob@example.net>" {
+		t.Errorf("Expected skipped rows to keep their message-id: %v", rows["2"])
+	}
+}
+
+func TestRunTornCheckpoint(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "bulk")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint.csv")
+	ioutil.WriteFile(path, []byte("ada@example.net,<ada@example.net>\nbob@exa"), 0644)
+
+	s := newFakeSender()
+	job := Job{TemplateID: 7, To: "email", Checkpoint: path}
+	in := recipients + ",Nobody,free\n"
+
+	var results bytes.Buffer
+	sum, err := Run(context.Background(), s, strings.NewReader(in), &results, job)
+	if err != nil {
+		t.Fatalf("A torn checkpoint is not being recovered: %v", err)
+	}
+	if sum != (Summary{Rows: 5, Sent: 2, Failed: 1, Skipped: 2}) || s.emails["ada@example.net"] != nil {
+		t.Errorf("Unexpected summary: %+v", sum)
+	}
+
+	rows := readResults(t, results.Bytes())
+	if rows["5"][3] != StatusFailed {
+		t.Errorf("Rows with an empty key are not failing: %v", rows["5"])
+	}
+}
+
+func TestRunSMS(t *testing.T) {
+
+	s := newFakeSender()
+	job := Job{
+		SMS:       sib.SMSRequest{From: "Shop", Text: "Hi %NAME%, your code is %code%", Type: "transactional"},
+		To:        "mobile",
+		SMSFields: map[string]string{"tag": "campaign"},
+	}
+
+	in := "mobile,name,code,campaign\n+3100000001,Ada,1234,spring\n"
+	var results bytes.Buffer
+	sum, err := Run(context.Background(), s, strings.NewReader(in), &results, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum.Sent != 1 || len(s.sms) != 1 {
+		t.Fatalf("Expected one SMS, got %+v", sum)
+	}
+	got := s.sms[0]
+	if got.To != "+3100000001" || got.From != "Shop" || got.Tag != "spring" || got.Text != "Hi Ada, your code is 1234" {
+		t.Errorf("Columns are not being mapped onto the SMS: %+v", got)
+	}
+	if !strings.Contains(results.String(), "ref-1") {
+		t.Errorf("Expected the SMS reference in the results:\n%s", results.String())
+	}
+}
+
+func TestRunUnknownColumn(t *testing.T) {
+
+	_, err := Run(context.Background(), newFakeSender(), strings.NewReader(recipients), ioutil.Discard, Job{TemplateID: 1, To: "mobile"})
+	if err == nil {
+		t.Error("Expected a missing recipient column to fail.")
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := newFakeSender()
+	_, err := Run(ctx, s, strings.NewReader(recipients), ioutil.Discard, Job{TemplateID: 1, To: "email"})
+	if err != context.Canceled {
+		t.Errorf("Expected the run to be cancelled, got %v", err)
+	}
+	if len(s.emails) != 0 {
+		t.Error("Emails were sent after cancellation.")
+	}
+}
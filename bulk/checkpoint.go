@@ -0,0 +1,116 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// checkpoint records the key and message-id of every row that was sent,
+// so an interrupted run can be resumed without sending twice.
+type checkpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *csv.Writer
+	done map[string]string
+}
+
+func openCheckpoint(path string) (*checkpoint, error) {
+
+	cp := &checkpoint{done: make(map[string]string)}
+	if path == "" {
+		return cp, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		err = fmt.Errorf("Could not open checkpoint file: %+v", err)
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		err = fmt.Errorf("Could not read checkpoint file: %+v", err)
+		return nil, err
+	}
+
+	// a crash during record leaves a torn last line; drop it, since its
+	// row was not reported as sent
+	if i := bytes.LastIndexByte(data, '\n'); i+1 < len(data) {
+		data = data[:i+1]
+		if err := f.Truncate(int64(len(data))); err != nil {
+			f.Close()
+			err = fmt.Errorf("Could not repair checkpoint file: %+v", err)
+			return nil, err
+		}
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = 2
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			err = fmt.Errorf("Could not read checkpoint file: %+v", err)
+			return nil, err
+		}
+		cp.done[rec[0]] = rec[1]
+	}
+
+	_, err = f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		err = fmt.Errorf("Could not open checkpoint file: %+v", err)
+		return nil, err
+	}
+
+	cp.f = f
+	cp.w = csv.NewWriter(f)
+
+	return cp, nil
+}
+
+func (cp *checkpoint) lookup(key string) (string, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	id, ok := cp.done[key]
+	return id, ok
+}
+
+// record durably marks key as sent before the result is reported.
+func (cp *checkpoint) record(key, messageID string) error {
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.done[key] = messageID
+	if cp.w == nil {
+		return nil
+	}
+
+	cp.w.Write([]string{key, messageID})
+	cp.w.Flush()
+	if err := cp.w.Error(); err != nil {
+		return fmt.Errorf("Could not write checkpoint file: %+v", err)
+	}
+	if err := cp.f.Sync(); err != nil {
+		return fmt.Errorf("Could not write checkpoint file: %+v", err)
+	}
+
+	return nil
+}
+
+func (cp *checkpoint) close() error {
+	if cp.f == nil {
+		return nil
+	}
+	return cp.f.Close()
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,26 +17,110 @@ type Client struct {
 	apiKey  string
 	Client  *http.Client
 	RawBody []byte
+
+	rawMu        sync.Mutex
+	strict       *templateCheck
+	idemOnce     sync.Once
+	idempotency  *idempotency
+	recipients   *RecipientPolicy
+	credentials  CredentialsProvider
+	breaker      *breaker
+	sandbox      *sandboxTransport
+	archive      Archive
+	generateText bool
+	htmlFilters  []HTMLFilter
+	linkParams   LinkParams
+	lint         *lintConfig
 }
 
+// An Option configures optional Client behaviour in NewClient.
+type Option func(*Client)
+
 // NewClient takes a private SendInBlue API key
 // and constructs a Client Object that can be used
 // to talk to the SendInBlue API via the Client methods.
-func NewClient(apiKey string) (*Client, error) {
+// The key may be empty when a WithCredentials option provides it.
+func NewClient(apiKey string, opts ...Option) (*Client, error) {
 
 	emptyClient := &Client{}
 
-	if apiKey == "" {
-		err := fmt.Errorf("Error: Please provide a SendInBlue API Key.")
-		return emptyClient, err
-	}
-
-	return &Client{
+	c := &Client{
 		apiKey: apiKey,
 		Client: &http.Client{ // could consider using fasthttp client -- but would introduce vendor dep
 			Timeout: time.Second * 60,
 		},
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.apiKey == "" && c.credentials == nil {
+		err := fmt.Errorf("Error: Please provide a SendInBlue API Key.")
+		return emptyClient, err
+	}
+
+	return c, nil
+}
+
+// key returns the API key for the next request.
+func (c *Client) key() (string, error) {
+
+	if c.credentials == nil {
+		return c.apiKey, nil
+	}
+
+	key, err := c.credentials.APIKey()
+	if err != nil {
+		err = fmt.Errorf("Could not get API key: %+v", err)
+		return "", err
+	}
+	if key == "" {
+		err = fmt.Errorf("Could not get API key: credentials provider returned an empty key")
+		return "", err
+	}
+
+	return key, nil
+}
+
+// ResponseError is returned by SendEmail when the API answers with a
+// server error, or with a body that cannot be read. After a 2xx status the
+// email may have been sent.
+type ResponseError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ResponseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// setRawBody records the last response body; the lock keeps concurrent
+// sends from racing on it.
+func (c *Client) setRawBody(b []byte) {
+	c.rawMu.Lock()
+	c.RawBody = b
+	c.rawMu.Unlock()
+}
+
+// do sends a request. The sandbox and circuit breaker are applied here
+// rather than in c.Client, so replacing c.Client or its Transport cannot
+// bypass them.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+
+	if c.sandbox != nil {
+		return c.sandbox.RoundTrip(req)
+	}
+
+	if c.breaker != nil {
+		return c.breaker.do(c.Client, req)
+	}
+
+	return c.Client.Do(req)
 }
 
 // AggregateReport is a Client Method for the SMTP API.
@@ -58,16 +143,20 @@ func (c *Client) AggregateReport(a *AggregateReport) (AggregateResponse, error)
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -101,16 +190,20 @@ func (c *Client) CreateSMSCampaign(s *SMSCampaign) (SMSCampaignResponse, error)
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -131,6 +224,11 @@ func (c *Client) CreateTemplate(t *Template) (TemplateResponse, error) {
 
 	emptyResp := TemplateResponse{}
 
+	t, err := c.filterTemplate(t)
+	if err != nil {
+		return emptyResp, err
+	}
+
 	body, err := json.Marshal(t)
 	if err != nil {
 		err = fmt.Errorf("Could not marshal JSON: %+v", err)
@@ -144,16 +242,20 @@ func (c *Client) CreateTemplate(t *Template) (TemplateResponse, error) {
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -193,16 +295,20 @@ func (c *Client) DeleteBouncedEmails(start, end, email string) error {
 		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err := fmt.Errorf("Request error: ", resp.Status)
+		err := fmt.Errorf("Request error: %s", resp.Status)
 		return err
 	}
 
@@ -220,16 +326,20 @@ func (c *Client) GetTemplate(template_id int) (CampaignResponse, error) {
 		err := fmt.Errorf("Could not create http request: %+v", err)
 		return emptyResp, err
 	}
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
+	if err != nil {
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -264,16 +374,20 @@ func (c *Client) ListTemplates(t *TemplateList) (TemplateListResponse, error) {
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
+	if err != nil {
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -294,6 +408,11 @@ func (c *Client) SendEmail(e *Email) (EmailResponse, error) {
 
 	emptyResp := EmailResponse{}
 
+	e, err := c.prepareEmail(e)
+	if err != nil {
+		return emptyResp, err
+	}
+
 	body, err := json.Marshal(e)
 	if err != nil {
 		err = fmt.Errorf("Could not marshal JSON: %+v", err)
@@ -307,36 +426,83 @@ func (c *Client) SendEmail(e *Email) (EmailResponse, error) {
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
-		return emptyResp, err
+		return emptyResp, &ResponseError{StatusCode: resp.StatusCode, Err: err}
 	}
 
 	var response EmailResponse
 	err = json.Unmarshal(b, &response)
 	if err != nil {
 		err := fmt.Errorf("Could not decode response format: %+v", err)
-		return emptyResp, err
+		return emptyResp, &ResponseError{StatusCode: resp.StatusCode, Err: err}
+	}
+	if resp.StatusCode >= 500 {
+		err := fmt.Errorf("API returned %s: %s", resp.Status, response.Message)
+		return response, &ResponseError{StatusCode: resp.StatusCode, Err: err}
+	}
+
+	err = c.archiveEmail(response, e)
+	if err != nil {
+		return response, err
 	}
 
 	return response, nil
 }
 
+// prepareEmail returns the email as it will be sent: filtered, with
+// generated text and with the recipient policy applied. e is not changed.
+func (c *Client) prepareEmail(e *Email) (*Email, error) {
+
+	e, err := c.filterEmail(e)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.generateText && e.Text == "" && e.HTML != "" {
+		withText := *e
+		withText.GenerateText()
+		e = &withText
+	}
+
+	if c.recipients != nil {
+		rewritten, err := c.recipients.RewriteEmail(e)
+		if err != nil {
+			return nil, err
+		}
+		e = rewritten
+	}
+
+	return e, nil
+}
+
 // SendSMS ...
 func (c *Client) SendSMS(s *SMSRequest) (SMSResponse, error) {
 
 	emptyResp := SMSResponse{}
 
+	if c.recipients != nil {
+		rewritten, err := c.recipients.RewriteSMS(s)
+		if err != nil {
+			return emptyResp, err
+		}
+		s = rewritten
+	}
+
 	body, err := json.Marshal(s)
 	if err != nil {
 		err = fmt.Errorf("Could not marshal JSON: %+v", err)
@@ -350,16 +516,20 @@ func (c *Client) SendSMS(s *SMSRequest) (SMSResponse, error) {
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -378,6 +548,17 @@ func (c *Client) SendSMS(s *SMSRequest) (SMSResponse, error) {
 // SendTemplateEmail ...
 func (c *Client) SendTemplateEmail(id int, to []string, e *EmailOptions) (EmailResponse, error) {
 
+	// a failed fetch does not stop the send; it is reported once the
+	// email is sent
+	template, terr := c.archivedTemplate(id)
+
+	return c.sendTemplateEmail(id, to, e, template, terr)
+}
+
+// sendTemplateEmail sends template id, archiving it with template, the
+// result of archivedTemplate, which also serves the strict check.
+func (c *Client) sendTemplateEmail(id int, to []string, e *EmailOptions, template *CampaignData, terr error) (EmailResponse, error) {
+
 	toString := strings.Join(to, "|")
 
 	email := TemplateEmail{}
@@ -395,6 +576,23 @@ func (c *Client) SendTemplateEmail(id int, to []string, e *EmailOptions) (EmailR
 
 	emptyResp := EmailResponse{}
 
+	if c.strict != nil {
+		if template != nil {
+			c.strict.remember(id, template)
+		}
+		err := c.strict.check(c, id, email.Attr)
+		if err != nil {
+			return emptyResp, err
+		}
+	}
+
+	if c.recipients != nil {
+		err := c.recipients.RewriteTemplateEmail(&email)
+		if err != nil {
+			return emptyResp, err
+		}
+	}
+
 	body, err := json.Marshal(email)
 	if err != nil {
 		err = fmt.Errorf("Could not marshal JSON: %+v", err)
@@ -409,16 +607,20 @@ func (c *Client) SendTemplateEmail(id int, to []string, e *EmailOptions) (EmailR
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -431,18 +633,31 @@ func (c *Client) SendTemplateEmail(id int, to []string, e *EmailOptions) (EmailR
 		return emptyResp, err
 	}
 
+	err = c.archiveTemplate(response, template, terr, &email)
+	if err != nil {
+		return response, err
+	}
+
 	return response, nil
 }
 
 // SMSCampaignTest ...
 func (c *Client) SMSCampaignTest(id int, to string) (SMSResponse, error) {
 
+	emptyResp := SMSResponse{}
+
+	if c.recipients != nil {
+		rewritten, err := c.recipients.RewriteSMS(&SMSRequest{To: to})
+		if err != nil {
+			return emptyResp, err
+		}
+		to = rewritten.To
+	}
+
 	request := SMSTest{
 		To: to,
 	}
 
-	emptyResp := SMSResponse{}
-
 	body, err := json.Marshal(request)
 	if err != nil {
 		err = fmt.Errorf("Could not marshal JSON: %+v", err)
@@ -457,16 +672,20 @@ func (c *Client) SMSCampaignTest(id int, to string) (SMSResponse, error) {
 		return emptyResp, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return emptyResp, err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return emptyResp, err
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	c.RawBody = b
+	c.setRawBody(b)
 	if err != nil {
 		err := fmt.Errorf("Could not recognize API response format: %+v", err)
 		return emptyResp, err
@@ -499,16 +718,20 @@ func (c *Client) UpdateSMSCampaign(id int, s *SMSCampaign) error {
 		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		return err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
+	if err != nil {
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err := fmt.Errorf("Request error: ", resp.Status)
+		err := fmt.Errorf("Request error: %s", resp.Status)
 		return err
 	}
 
@@ -518,6 +741,11 @@ func (c *Client) UpdateSMSCampaign(id int, s *SMSCampaign) error {
 // UpdateTemplate ...
 func (c *Client) UpdateTemplate(id int, t *Template) error {
 
+	t, err := c.filterTemplate(t)
+	if err != nil {
+		return err
+	}
+
 	body, err := json.Marshal(t)
 	if err != nil {
 		err = fmt.Errorf("Could not marshal JSON: %+v", err)
@@ -532,16 +760,24 @@ func (c *Client) UpdateTemplate(id int, t *Template) error {
 		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("api-key", c.apiKey)
-	resp, err := c.Client.Do(req)
+	key, err := c.key()
+	if err != nil {
+		return err
+	}
+	req.Header.Add("api-key", key)
+	resp, err := c.do(req)
 	if err != nil {
-		err := fmt.Errorf("Could not send http request: %+v", err)
+		err := fmt.Errorf("Could not send http request: %w", err)
 		return err
 	}
 	defer resp.Body.Close()
 
+	if c.strict != nil {
+		c.strict.forget(id)
+	}
+
 	if resp.StatusCode != 200 {
-		err := fmt.Errorf("Request error: ", resp.Status)
+		err := fmt.Errorf("Request error: %s", resp.Status)
 		return err
 	}
 
@@ -1,6 +1,9 @@
 package sib
 
 import (
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 )
@@ -32,3 +35,33 @@ func TestNewClient(t *testing.T) {
 		t.Error("Request timeout is not being set.")
 	}
 }
+
+// roundTripFunc lets tests answer the Client's HTTP requests in-process.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// jsonResponse builds a 200 response with the given JSON body.
+func jsonResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: 200,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestNewClientOptions(t *testing.T) {
+
+	called := false
+	_, err := NewClient("123", func(c *Client) { called = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !called {
+		t.Error("Options are not being applied.")
+	}
+}
@@ -0,0 +1,124 @@
+// Command sib-lint checks email HTML files for common problems, for use
+// in CI before templates are uploaded or emails sent.
+//
+//	sib-lint [-marketing] [-attr NAME,...] [-format text|json] [-fail-on error|warning] file...
+//
+// With no files the HTML is read from standard input. The front-matter
+// of sib-templates files is skipped.
+// The exit status is 1 when any finding is at or above -fail-on.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type fileFindings struct {
+	File     string        `json:"file"`
+	Findings []sib.Finding `json:"findings"`
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("sib-lint: ")
+
+	marketing := flag.Bool("marketing", false, "require an unsubscribe link")
+	attrs := flag.String("attr", "", "comma-separated known placeholder names; enables the placeholder check")
+	format := flag.String("format", "text", "output format: text or json")
+	failOn := flag.String("fail-on", "error", "lowest severity that fails the run: info, warning or error")
+	maxSize := flag.Int("max-size", sib.GmailClipSize, "HTML size in bytes above which to warn")
+	flag.Parse()
+
+	threshold, err := sib.ParseSeverity(*failOn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	opts := sib.LintOptions{Marketing: *marketing, MaxSize: *maxSize}
+	if *attrs != "" {
+		opts.Attributes = strings.Split(*attrs, ",")
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	var results []fileFindings
+	failed := false
+	for _, file := range files {
+		html, skipped, err := readHTML(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		findings := sib.LintHTML(html, opts)
+		for i, f := range findings {
+			if f.Line > 0 {
+				findings[i].Line += skipped
+			}
+			if f.Severity >= threshold {
+				failed = true
+			}
+		}
+		if findings == nil {
+			findings = []sib.Finding{}
+		}
+		results = append(results, fileFindings{file, findings})
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(results)
+	} else {
+		for _, r := range results {
+			for _, f := range r.Findings {
+				fmt.Printf("%s:%s\n", r.File, f)
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readHTML returns the HTML in file and the number of front-matter
+// lines before it.
+func readHTML(file string) (string, int, error) {
+
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	// skip front-matter: everything up to the second "---" line
+	lines := strings.SplitAfter(string(b), "\n")
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "---" {
+				return strings.Join(lines[i+1:], ""), i + 1, nil
+			}
+		}
+	}
+
+	return string(b), 0, nil
+}
@@ -0,0 +1,170 @@
+// Command sib-preview serves local previews of template emails.
+//
+// Every *.json file in the data directory describes one preview:
+//
+//	{
+//	  "template_id": 12,
+//	  "to": "ada@example.net",
+//	  "attr": {"FIRSTNAME": "Ada", "PLAN": "pro"}
+//	}
+//
+// Instead of "template_id", "template" may name a local HTML file with
+// front-matter as used by sib-templates. Templates fetched by ID need the
+// API key in the SIB_KEY environment variable. Files are re-read on every
+// request, so edits show up on reload. Nothing is ever sent.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+	"github.com/JKhawaja/sendinblue/templatesync"
+)
+
+type sample struct {
+	TemplateID int               `json:"template_id"`
+	Template   string            `json:"template"`
+	To         string            `json:"to"`
+	Attr       map[string]string `json:"attr"`
+}
+
+type server struct {
+	dir    string
+	client *sib.Client
+}
+
+var indexPage = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<title>sib-preview</title>
+<h1>Template previews</h1>
+<ul>{{range .}}<li><a href="/preview/{{.}}">{{.}}</a></li>{{else}}<li>no *.json samples found</li>{{end}}</ul>
+`))
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<title>{{.Name}} - sib-preview</title>
+<p><a href="/">&larr; all previews</a></p>
+<table>
+<tr><th align="left">To</th><td>{{.Email.To}}</td></tr>
+<tr><th align="left">Subject</th><td>{{.Email.Subject}}</td></tr>
+{{if .Email.Missing}}<tr><th align="left">Missing</th><td style="color:#b00">{{range .Email.Missing}}{{.}} {{end}}</td></tr>{{end}}
+</table>
+<iframe src="/html/{{.Name}}" style="width:100%;height:80vh;border:1px solid #ccc"></iframe>
+`))
+
+func main() {
+	addr := flag.String("addr", "localhost:8025", "address to serve previews on")
+	dir := flag.String("data", ".", "directory containing the *.json sample data files")
+	flag.Parse()
+
+	s := &server{dir: *dir}
+
+	if key := os.Getenv("SIB_KEY"); key != "" {
+		c, err := sib.NewClient(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.client = c
+	}
+
+	http.HandleFunc("/", s.index)
+	http.HandleFunc("/preview/", s.preview)
+	http.HandleFunc("/html/", s.html)
+
+	log.Printf("serving previews of %s on http://%s/", *dir, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func (s *server) index(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	var names []string
+	for _, p := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(p), ".json"))
+	}
+	sort.Strings(names)
+
+	indexPage.Execute(w, names)
+}
+
+func (s *server) preview(w http.ResponseWriter, r *http.Request) {
+
+	name := strings.TrimPrefix(r.URL.Path, "/preview/")
+	email, err := s.render(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	previewPage.Execute(w, struct {
+		Name  string
+		Email sib.RenderedEmail
+	}{name, email})
+}
+
+func (s *server) html(w http.ResponseWriter, r *http.Request) {
+
+	name := strings.TrimPrefix(r.URL.Path, "/html/")
+	email, err := s.render(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, email.HTML)
+}
+
+func (s *server) render(name string) (sib.RenderedEmail, error) {
+
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return sib.RenderedEmail{}, fmt.Errorf("Invalid sample name %q", name)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, name+".json"))
+	if err != nil {
+		return sib.RenderedEmail{}, fmt.Errorf("Could not read sample: %+v", err)
+	}
+
+	var smp sample
+	err = json.Unmarshal(b, &smp)
+	if err != nil {
+		return sib.RenderedEmail{}, fmt.Errorf("Could not decode sample %q: %+v", name, err)
+	}
+
+	email := &sib.TemplateEmail{To: smp.To, Attr: smp.Attr}
+
+	if smp.Template != "" {
+		path := smp.Template
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(s.dir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return sib.RenderedEmail{}, fmt.Errorf("Could not read template: %+v", err)
+		}
+		local, err := templatesync.ParseTemplate(name, data)
+		if err != nil {
+			return sib.RenderedEmail{}, err
+		}
+		return sib.RenderTemplate(&sib.CampaignData{Subject: local.Subject, Html_content: local.HTML}, email), nil
+	}
+
+	if s.client == nil {
+		return sib.RenderedEmail{}, fmt.Errorf("Sample %q uses template_id; set SIB_KEY to fetch templates", name)
+	}
+	return s.client.PreviewTemplate(smp.TemplateID, email)
+}
@@ -0,0 +1,100 @@
+// Command sib-smtp-bridge accepts mail over SMTP on a local port and
+// sends it through the SendInBlue API.
+//
+//	sib-smtp-bridge -addr localhost:2525 -user app:secret -allow @example.com
+//
+// Without -user any client may send. Credentials are only accepted over
+// STARTTLS, which -tls-cert and -tls-key enable, unless -insecure-auth is
+// given. The API key is read from the SIB_KEY environment variable.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/JKhawaja/sendinblue"
+	"github.com/JKhawaja/sendinblue/smtpbridge"
+)
+
+// multiFlag is a flag that may be repeated.
+type multiFlag []string
+
+func (m *multiFlag) String() string { return strings.Join(*m, ",") }
+
+func (m *multiFlag) Set(s string) error {
+	*m = append(*m, s)
+	return nil
+}
+
+func main() {
+	var users, allow multiFlag
+	addr := flag.String("addr", "localhost:2525", "address to listen on")
+	hostname := flag.String("hostname", "localhost", "host name announced in the greeting")
+	flag.Var(&users, "user", "`name:password` allowed to authenticate (repeatable)")
+	flag.Var(&allow, "allow", "sender address or @domain allowed to send (repeatable)")
+	rate := flag.Float64("rate", 0, "messages per second allowed per client (0 = unlimited)")
+	burst := flag.Int("burst", 10, "burst size for -rate")
+	maxSize := flag.Int64("max-size", 10<<20, "maximum message size in bytes")
+	certFile := flag.String("tls-cert", "", "certificate file for STARTTLS")
+	keyFile := flag.String("tls-key", "", "key file for STARTTLS")
+	insecureAuth := flag.Bool("insecure-auth", false, "accept credentials without TLS")
+	flag.Parse()
+
+	sibClient, err := sib.NewClient(os.Getenv("SIB_KEY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := &smtpbridge.Server{
+		Addr:         *addr,
+		Hostname:     *hostname,
+		Sender:       sibClient,
+		AllowSenders: allow,
+		Rate:         *rate,
+		Burst:        *burst,
+		MaxSize:      *maxSize,
+
+		AllowInsecureAuth: *insecureAuth,
+	}
+
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	if len(users) > 0 {
+		passwords := make(map[string]string)
+		for _, u := range users {
+			i := strings.IndexByte(u, ':')
+			if i < 0 {
+				log.Fatalf("-user %q is not name:password", u)
+			}
+			passwords[u[:i]] = u[i+1:]
+		}
+		server.Auth = func(name, password string) bool {
+			p, ok := passwords[name]
+			return ok && p == password
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		server.Close()
+	}()
+
+	log.Printf("listening on %s", *addr)
+	err = server.ListenAndServe()
+	if err != nil && err != smtpbridge.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
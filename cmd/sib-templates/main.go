@@ -0,0 +1,101 @@
+// Command sib-templates synchronises a directory of HTML email templates
+// with a SendInBlue account.
+//
+// Each *.html file in the directory is a template named after the file.
+// Its front-matter holds the subject, sender, reply-to and status:
+//
+//	---
+//	subject: Welcome aboard
+//	from: Sender Name <sender@example.net>
+//	reply-to: support@example.net
+//	status: active
+//	---
+//	<html>...</html>
+//
+// Template IDs are recorded in a lock file so that later runs update
+// the same remote templates. Templates whose file has been removed are
+// deactivated. With -inline-css the templates' style sheets are inlined
+// before comparing and uploading. The API key is read from the SIB_KEY
+// environment variable.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/JKhawaja/sendinblue"
+	"github.com/JKhawaja/sendinblue/templatesync"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory containing the *.html templates")
+	lockPath := flag.String("lock", "", "lock file mapping template names to IDs (default <dir>/sib-templates.lock)")
+	dryRun := flag.Bool("dry-run", false, "print the planned changes without applying them")
+	verbose := flag.Bool("v", false, "also list unchanged templates")
+	inlineCSS := flag.Bool("inline-css", false, "inline <style> rules into style attributes")
+	flag.Parse()
+
+	if *lockPath == "" {
+		*lockPath = filepath.Join(*dir, "sib-templates.lock")
+	}
+
+	sibClient, err := sib.NewClient(os.Getenv("SIB_KEY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	local, err := templatesync.LoadDir(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *inlineCSS {
+		for i := range local {
+			local[i].HTML = sib.InlineCSS(local[i].HTML)
+		}
+	}
+
+	lock, err := templatesync.ReadLock(*lockPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	changes, err := templatesync.Plan(sibClient, local, lock)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pending := 0
+	for _, c := range changes {
+		if c.Action != templatesync.Unchanged {
+			pending++
+		}
+		if c.Action != templatesync.Unchanged || *verbose {
+			fmt.Println(c)
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("dry run: %d change(s) not applied\n", pending)
+		return
+	}
+	if pending == 0 {
+		fmt.Println("templates are up to date")
+		return
+	}
+
+	applyErr := templatesync.Apply(sibClient, changes, lock)
+
+	// write the lock even after a failure so that created templates are not created twice
+	err = lock.Write(*lockPath)
+	if err != nil {
+		log.Println(err)
+	}
+
+	if applyErr != nil {
+		log.Fatal(applyErr)
+	}
+	fmt.Printf("applied %d change(s)\n", pending)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+	"github.com/JKhawaja/sendinblue/bulk"
+)
+
+type bulkFlags struct {
+	csv, results, checkpoint, to, key *string
+	workers                           *int
+	rate                              *float64
+}
+
+func addBulkFlags(fs *flag.FlagSet) bulkFlags {
+	return bulkFlags{
+		csv:        fs.String("csv", "", "CSV file of recipients, with a header row"),
+		results:    fs.String("results", "", "results CSV file (default <csv>.results.csv)"),
+		checkpoint: fs.String("checkpoint", "", "checkpoint file used to resume (default <csv>.checkpoint)"),
+		to:         fs.String("to", "", "column holding the recipient"),
+		key:        fs.String("key", "", "column identifying a row when resuming (default -to)"),
+		workers:    fs.Int("workers", 4, "concurrent sends"),
+		rate:       fs.Float64("rate", 10, "maximum sends per second, 0 for no limit"),
+	}
+}
+
+// mapping parses repeated NAME=column flags.
+func mapping(list multiFlag) (map[string]string, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, s := range list {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid mapping %q, want NAME=column", s)
+		}
+		m[s[:i]] = s[i+1:]
+	}
+	return m, nil
+}
+
+func runBulk(c *sib.Client, out *printer, f bulkFlags, job bulk.Job) error {
+
+	job.To = *f.to
+	job.Key = *f.key
+	job.Workers = *f.workers
+	job.Rate = *f.rate
+	job.Checkpoint = *f.checkpoint
+	if job.Checkpoint == "" {
+		job.Checkpoint = *f.csv + ".checkpoint"
+	}
+	if *f.results == "" {
+		*f.results = *f.csv + ".results.csv"
+	}
+
+	in, err := os.Open(*f.csv)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	results, err := os.Create(*f.results)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	// stop feeding rows on interrupt; sends in flight are finished and checkpointed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sum, err := bulk.Run(ctx, c, in, results, job)
+	if printErr := out.Print(sum); printErr != nil && err == nil {
+		err = printErr
+	}
+	if err == context.Canceled {
+		return fmt.Errorf("interrupted; run again to resume from %s", job.Checkpoint)
+	}
+	return err
+}
+
+func bulkEmail(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("bulk email")
+	f := addBulkFlags(fs)
+	var attrs multiFlag
+	fs.Var(&attrs, "attr", "map a template attribute to a column as NAME=column (repeatable, default all columns)")
+	id := fs.Int("template", 0, "template ID")
+	replyTo := fs.String("reply-to", "", "reply-to address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "template", "csv", "to"); err != nil {
+		return err
+	}
+
+	attr, err := mapping(attrs)
+	if err != nil {
+		return err
+	}
+
+	return runBulk(c, out, f, bulk.Job{
+		TemplateID: *id,
+		Options:    sib.NewEmailOptions(*replyTo, "", nil, nil),
+		Attr:       attr,
+	})
+}
+
+func bulkSMS(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("bulk sms")
+	f := addBulkFlags(fs)
+	var fields multiFlag
+	fs.Var(&fields, "field", "map an SMS field (from, text, tag, web_url, type) to a column as field=column (repeatable)")
+	from := fs.String("from", "", "sender, at most 11 alphanumeric characters")
+	text := fs.String("text", "", "message; %COLUMN% is replaced by the row's value")
+	tag := fs.String("tag", "", "tag for statistics")
+	typ := fs.String("type", "marketing", "marketing or transactional")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "csv", "to"); err != nil {
+		return err
+	}
+
+	smsFields, err := mapping(fields)
+	if err != nil {
+		return err
+	}
+
+	return runBulk(c, out, f, bulk.Job{
+		SMS:       sib.SMSRequest{From: *from, Text: *text, Tag: *tag, Type: *typ},
+		SMSFields: smsFields,
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+func emailSend(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("email send")
+	var to, cc, bcc, headers multiFlag
+	fs.Var(&to, "to", "recipient, as addr or \"Name <addr>\" (repeatable)")
+	fs.Var(&cc, "cc", "cc recipient (repeatable)")
+	fs.Var(&bcc, "bcc", "bcc recipient (repeatable)")
+	fs.Var(&headers, "header", "extra header as Name:Value (repeatable)")
+	from := fs.String("from", "", "sender, as addr or \"Name <addr>\"")
+	replyTo := fs.String("reply-to", "", "reply-to address")
+	subject := fs.String("subject", "", "subject line")
+	html := fs.String("html", "", "HTML body")
+	htmlFile := fs.String("html-file", "", "read the HTML body from a file (- for stdin)")
+	text := fs.String("text", "", "plain text body")
+	textFile := fs.String("text-file", "", "read the plain text body from a file (- for stdin)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "from", "subject"); err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("-to is required")
+	}
+
+	email := sib.NewEmail()
+	email.Subject = *subject
+
+	var err error
+	if email.From, err = pair(*from); err != nil {
+		return err
+	}
+	if *replyTo != "" {
+		if email.ReplyTo, err = pair(*replyTo); err != nil {
+			return err
+		}
+	}
+	for _, set := range []struct {
+		list multiFlag
+		m    map[string]string
+	}{{to, email.To}, {cc, email.CC}, {bcc, email.Bcc}} {
+		for _, s := range set.list {
+			p, err := pair(s)
+			if err != nil {
+				return err
+			}
+			set.m[p[0]] = p[1]
+		}
+	}
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		if i < 0 {
+			return fmt.Errorf("invalid header %q, want Name:Value", h)
+		}
+		email.Headers[strings.TrimSpace(h[:i])] = strings.TrimSpace(h[i+1:])
+	}
+
+	if email.HTML, err = readContent(*html, *htmlFile); err != nil {
+		return err
+	}
+	if email.Text, err = readContent(*text, *textFile); err != nil {
+		return err
+	}
+	if email.HTML == "" && email.Text == "" {
+		return fmt.Errorf("one of -html, -html-file, -text or -text-file is required")
+	}
+
+	resp, err := c.SendEmail(email)
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+func emailTemplateSend(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("email template-send")
+	var to, cc, bcc, attrs multiFlag
+	fs.Var(&to, "to", "recipient address (repeatable)")
+	fs.Var(&cc, "cc", "cc address (repeatable)")
+	fs.Var(&bcc, "bcc", "bcc address (repeatable)")
+	fs.Var(&attrs, "attr", "template attribute as NAME=VALUE (repeatable)")
+	id := fs.Int("id", 0, "template ID")
+	replyTo := fs.String("reply-to", "", "reply-to address")
+	attachmentURL := fs.String("attachment-url", "", "URL of a file to attach")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "id"); err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("-to is required")
+	}
+
+	options := sib.NewEmailOptions(*replyTo, *attachmentURL, cc, bcc)
+	for _, a := range attrs {
+		i := strings.Index(a, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid attribute %q, want NAME=VALUE", a)
+		}
+		options.Attr[a[:i]] = a[i+1:]
+	}
+
+	resp, err := c.SendTemplateEmail(*id, to, options)
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+// pair turns "Name <addr>" or "addr" into the [addr, name] pair used by sib.Email.
+func pair(s string) ([2]string, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return [2]string{}, fmt.Errorf("invalid address %q: %v", s, err)
+	}
+	return [2]string{addr.Address, addr.Name}, nil
+}
@@ -0,0 +1,237 @@
+// Command sib is a command-line client for everyday SendInBlue operations.
+//
+// Usage:
+//
+//	sib [-o table|json] [-config file] [-sandbox] <command> [subcommand] [flags]
+//
+// Commands:
+//
+//	email send               send a transactional email
+//	email template-send      send a template email
+//	sms send                 send a transactional SMS
+//	sms campaign create      create an SMS campaign
+//	sms campaign update      update an SMS campaign
+//	sms campaign test        send a test of an SMS campaign
+//	templates list           list email templates
+//	templates get            show an email template
+//	templates create         create an email template
+//	templates update         update an email template
+//	stats                    show the SMTP statistics report
+//	bounces delete           delete bounced emails
+//	bulk email               send a template email to every row of a CSV
+//	bulk sms                 send an SMS to every row of a CSV
+//
+// Run a command with -h to list its flags. The API key is read from the
+// SIB_KEY environment variable, or else from the "api_key" field of the
+// JSON config file (default ~/.sib.json).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type config struct {
+	APIKey string `json:"api_key"`
+	Output string `json:"output"`
+}
+
+type command struct {
+	name string
+	help string
+	run  func(c *sib.Client, out *printer, args []string) error
+}
+
+var commands = []command{
+	{"email send", "send a transactional email", emailSend},
+	{"email template-send", "send a template email", emailTemplateSend},
+	{"sms send", "send a transactional SMS", smsSend},
+	{"sms campaign create", "create an SMS campaign", smsCampaignCreate},
+	{"sms campaign update", "update an SMS campaign", smsCampaignUpdate},
+	{"sms campaign test", "send a test of an SMS campaign", smsCampaignTest},
+	{"templates list", "list email templates", templatesList},
+	{"templates get", "show an email template", templatesGet},
+	{"templates create", "create an email template", templatesCreate},
+	{"templates update", "update an email template", templatesUpdate},
+	{"stats", "show the SMTP statistics report", stats},
+	{"bounces delete", "delete bounced emails", bouncesDelete},
+	{"bulk email", "send a template email to every row of a CSV", bulkEmail},
+	{"bulk sms", "send an SMS to every row of a CSV", bulkSMS},
+}
+
+func main() {
+	flag.Usage = usage
+	output := flag.String("o", "", "output format: table or json (default table)")
+	configPath := flag.String("config", defaultConfigPath(), "JSON config file holding api_key")
+	sandbox := flag.Bool("sandbox", false, "validate and log requests to stderr instead of sending them")
+	flag.Parse()
+
+	cmd, args := lookup(flag.Args())
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := readConfig(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if key := os.Getenv("SIB_KEY"); key != "" {
+		cfg.APIKey = key
+	}
+	if *output != "" {
+		cfg.Output = *output
+	}
+
+	out, err := newPrinter(os.Stdout, cfg.Output)
+	if err != nil {
+		fatal(err)
+	}
+
+	var opts []sib.Option
+	if *sandbox {
+		opts = append(opts, sib.WithSandbox(sib.LogSink(log.New(os.Stderr, "", 0))))
+		if cfg.APIKey == "" {
+			cfg.APIKey = "sandbox"
+		}
+	}
+
+	client, err := sib.NewClient(cfg.APIKey, opts...)
+	if err != nil {
+		fatal(err)
+	}
+
+	err = cmd.run(client, out, args)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// lookup finds the longest command matching the leading arguments.
+func lookup(args []string) (*command, []string) {
+
+	var found *command
+	var rest []string
+
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") != commands[i].name {
+			continue
+		}
+		if found == nil || len(words) > len(strings.Fields(found.name)) {
+			found, rest = &commands[i], args[len(words):]
+		}
+	}
+
+	return found, rest
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: sib [-o table|json] [-config file] [-sandbox] <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-22s %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nGlobal flags:\n")
+	flag.PrintDefaults()
+}
+
+func defaultConfigPath() string {
+	if p := os.Getenv("SIB_CONFIG"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sib.json")
+}
+
+func readConfig(path string) (config, error) {
+
+	var cfg config
+	if path == "" {
+		return cfg, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("Could not read config file: %+v", err)
+	}
+
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("Could not decode config file %s: %+v", path, err)
+	}
+
+	return cfg, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "sib:", err)
+	os.Exit(1)
+}
+
+// newFlagSet returns a flag set for a command that reports errors
+// instead of exiting, so main decides the exit status.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("sib "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// multiFlag is a flag that may be repeated.
+type multiFlag []string
+
+func (m *multiFlag) String() string { return strings.Join(*m, ",") }
+
+func (m *multiFlag) Set(s string) error {
+	*m = append(*m, s)
+	return nil
+}
+
+// readContent returns s, or the contents of file when s is empty.
+func readContent(s, file string) (string, error) {
+	if file == "" {
+		return s, nil
+	}
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := ioutil.ReadAll(r)
+	return string(b), err
+}
+
+// required reports the first empty flag value among names.
+func required(fs *flag.FlagSet, names ...string) error {
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f != nil && (f.Value.String() == "" || f.Value.String() == "0") {
+			return fmt.Errorf("-%s is required", name)
+		}
+	}
+	return nil
+}
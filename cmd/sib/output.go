@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxCell is the widest a table cell may get before it is truncated.
+const maxCell = 60
+
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "", "table":
+		return &printer{w: w}, nil
+	case "json":
+		return &printer{w: w, json: true}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, want table or json", format)
+}
+
+// Print writes an API response as indented JSON or as tables: scalar
+// fields become key/value rows and slices of records become columns.
+func (p *printer) Print(v interface{}) error {
+
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	var rows [][2]string
+	var tables []reflect.Value
+	flatten(reflect.ValueOf(v), "", &rows, &tables)
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\n", r[0], cell(r[1]))
+	}
+	tw.Flush()
+
+	for _, t := range tables {
+		fmt.Fprintln(p.w)
+		printTable(p.w, t)
+	}
+
+	return nil
+}
+
+// Message prints a status line for commands without a response body.
+func (p *printer) Message(msg string) error {
+	if p.json {
+		return p.Print(map[string]string{"code": "success", "message": msg})
+	}
+	_, err := fmt.Fprintln(p.w, msg)
+	return err
+}
+
+func flatten(v reflect.Value, prefix string, rows *[][2]string, tables *[]reflect.Value) {
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		name := prefix + fieldName(t.Field(i))
+
+		switch {
+		case f.Kind() == reflect.Struct:
+			flatten(f, name+".", rows, tables)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct:
+			*tables = append(*tables, f)
+		default:
+			*rows = append(*rows, [2]string{name, fmt.Sprint(f.Interface())})
+		}
+	}
+}
+
+func printTable(w io.Writer, v reflect.Value) {
+
+	t := v.Type().Elem()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	var header []string
+	for i := 0; i < t.NumField(); i++ {
+		header = append(header, strings.ToUpper(fieldName(t.Field(i))))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for i := 0; i < v.Len(); i++ {
+		var cells []string
+		for j := 0; j < t.NumField(); j++ {
+			cells = append(cells, cell(fmt.Sprint(v.Index(i).Field(j).Interface())))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
+
+func fieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
+
+func cell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > maxCell {
+		s = s[:maxCell-3] + "..."
+	}
+	return s
+}
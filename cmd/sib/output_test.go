@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+func TestLookup(t *testing.T) {
+
+	cmd, rest := lookup([]string{"sms", "campaign", "test", "-id", "3"})
+	if cmd == nil || cmd.name != "sms campaign test" {
+		t.Fatalf("Expected sms campaign test, got %v", cmd)
+	}
+	if strings.Join(rest, " ") != "-id 3" {
+		t.Errorf("Expected the flags to be passed on, got %v", rest)
+	}
+
+	if cmd, _ := lookup([]string{"sms"}); cmd != nil {
+		t.Errorf("Expected an incomplete command to fail, got %v", cmd.name)
+	}
+}
+
+func TestPrinterTable(t *testing.T) {
+
+	var buf bytes.Buffer
+	out, _ := newPrinter(&buf, "table")
+
+	out.Print(sib.AggregateResponse{
+		Code: "success",
+		Data: []sib.AggregateData{{Date: "2017-11-28", Requests: 12, Delivered: 11}},
+	})
+
+	s := buf.String()
+	for _, want := range []string{"code", "success", "DATE", "REQUESTS", "2017-11-28", "12"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Expected %q in table output:\n%s", want, s)
+		}
+	}
+}
+
+func TestPrinterJSON(t *testing.T) {
+
+	var buf bytes.Buffer
+	out, _ := newPrinter(&buf, "json")
+
+	out.Print(sib.EmailResponse{Code: "success", Data: sib.EmailData{Message_id: "<1@example.net>"}})
+
+	if !strings.Contains(buf.String(), `"message-id": "<1@example.net>"`) {
+		t.Errorf("Unexpected JSON output:\n%s", buf.String())
+	}
+
+	if _, err := newPrinter(&buf, "xml"); err == nil {
+		t.Error("Expected an unknown output format to fail.")
+	}
+}
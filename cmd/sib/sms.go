@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+func smsSend(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("sms send")
+	to := fs.String("to", "", "mobile number")
+	from := fs.String("from", "", "sender, at most 11 alphanumeric characters")
+	text := fs.String("text", "", "message, at most 160 characters")
+	tag := fs.String("tag", "", "tag for statistics")
+	webURL := fs.String("web-url", "", "URL notified of delivery reports")
+	typ := fs.String("type", "transactional", "marketing or transactional")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "to", "from", "text"); err != nil {
+		return err
+	}
+
+	resp, err := c.SendSMS(&sib.SMSRequest{
+		To:      *to,
+		From:    *from,
+		Text:    *text,
+		Web_url: *webURL,
+		Tag:     *tag,
+		Type:    *typ,
+	})
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+type campaignFlags struct {
+	name, sender, content, lists, exclude, scheduled *string
+	sendNow                                          *bool
+}
+
+func addCampaignFlags(fs *flag.FlagSet) campaignFlags {
+	return campaignFlags{
+		name:      fs.String("name", "", "campaign name"),
+		sender:    fs.String("sender", "", "sender name"),
+		content:   fs.String("content", "", "message content"),
+		lists:     fs.String("lists", "", "comma separated list IDs to send to"),
+		exclude:   fs.String("exclude", "", "comma separated list IDs to exclude"),
+		scheduled: fs.String("scheduled", "", "send date as \"YYYY-MM-DD HH:MM:SS\""),
+		sendNow:   fs.Bool("send-now", false, "mark the campaign as ready to send"),
+	}
+}
+
+func (f campaignFlags) campaign() (*sib.SMSCampaign, error) {
+
+	lists, err := intList(*f.lists)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := intList(*f.exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sib.SMSCampaign{
+		Name:           *f.name,
+		Sender:         *f.sender,
+		Content:        *f.content,
+		List_ids:       lists,
+		Exclude_list:   exclude,
+		Scheduled_date: *f.scheduled,
+	}
+	if *f.sendNow {
+		s.Send_now = 1
+	}
+
+	return s, nil
+}
+
+func smsCampaignCreate(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("sms campaign create")
+	f := addCampaignFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "name"); err != nil {
+		return err
+	}
+
+	s, err := f.campaign()
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.CreateSMSCampaign(s)
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+func smsCampaignUpdate(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("sms campaign update")
+	id := fs.Int("id", 0, "campaign ID")
+	f := addCampaignFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "id", "name"); err != nil {
+		return err
+	}
+
+	s, err := f.campaign()
+	if err != nil {
+		return err
+	}
+
+	err = c.UpdateSMSCampaign(*id, s)
+	if err != nil {
+		return err
+	}
+	return out.Message(fmt.Sprintf("SMS campaign %d updated", *id))
+}
+
+func smsCampaignTest(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("sms campaign test")
+	id := fs.Int("id", 0, "campaign ID")
+	to := fs.String("to", "", "mobile number to send the test to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "id", "to"); err != nil {
+		return err
+	}
+
+	resp, err := c.SMSCampaignTest(*id, *to)
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+func intList(s string) ([]int, error) {
+	var ids []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list ID %q", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import "github.com/JKhawaja/sendinblue"
+
+func stats(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("stats")
+	start := fs.String("start", "", "start date, YYYY-MM-DD")
+	end := fs.String("end", "", "end date, YYYY-MM-DD")
+	days := fs.Int("days", 0, "number of past days, instead of -start and -end")
+	tag := fs.String("tag", "", "only count messages with this tag")
+	aggregate := fs.Bool("aggregate", false, "sum over the whole period instead of per day")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report := &sib.AggregateReport{
+		Start_date: *start,
+		End_date:   *end,
+		Days:       *days,
+		Tag:        *tag,
+	}
+	if *aggregate {
+		report.Aggregate = 1
+	}
+
+	resp, err := c.AggregateReport(report)
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+func bouncesDelete(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("bounces delete")
+	start := fs.String("start", "", "start date, YYYY-MM-DD")
+	end := fs.String("end", "", "end date, YYYY-MM-DD")
+	email := fs.String("email", "", "only delete bounces of this address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	err := c.DeleteBouncedEmails(*start, *end, *email)
+	if err != nil {
+		return err
+	}
+	return out.Message("bounces deleted")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+func templatesList(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("templates list")
+	status := fs.String("status", "", "filter by status: draft, sent, archive, queued, suspended, in_process or temp_active")
+	page := fs.Int("page", 1, "page number")
+	limit := fs.Int("limit", 50, "records per page")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	resp, err := c.ListTemplates(&sib.TemplateList{
+		Type:       "template",
+		Status:     *status,
+		Page:       *page,
+		Page_limit: *limit,
+	})
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+func templatesGet(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("templates get")
+	id := fs.Int("id", 0, "template ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "id"); err != nil {
+		return err
+	}
+
+	resp, err := c.GetTemplate(*id)
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+type templateFlags struct {
+	name, subject, from, replyTo, toField, html, htmlFile, htmlURL, attachmentURL *string
+	active                                                                        *bool
+}
+
+func addTemplateFlags(fs *flag.FlagSet) templateFlags {
+	return templateFlags{
+		name:          fs.String("name", "", "template name"),
+		subject:       fs.String("subject", "", "subject line"),
+		from:          fs.String("from", "", "sender, as addr or \"Name <addr>\""),
+		replyTo:       fs.String("reply-to", "", "reply-to address"),
+		toField:       fs.String("to-field", "", "personalised To field, e.g. [PRENOM] [NOM]"),
+		html:          fs.String("html", "", "HTML content"),
+		htmlFile:      fs.String("html-file", "", "read the HTML content from a file (- for stdin)"),
+		htmlURL:       fs.String("html-url", "", "URL of the HTML content"),
+		attachmentURL: fs.String("attachment-url", "", "URL of a file to attach"),
+		active:        fs.Bool("active", false, "activate the template"),
+	}
+}
+
+func (f templateFlags) template() (*sib.Template, error) {
+
+	addr, err := mail.ParseAddress(*f.from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -from %q: %v", *f.from, err)
+	}
+
+	html, err := readContent(*f.html, *f.htmlFile)
+	if err != nil {
+		return nil, err
+	}
+	if html == "" && *f.htmlURL == "" {
+		return nil, fmt.Errorf("one of -html, -html-file or -html-url is required")
+	}
+
+	t := &sib.Template{
+		From_name:      addr.Name,
+		Template_name:  *f.name,
+		Html_content:   html,
+		Html_url:       *f.htmlURL,
+		Subject:        *f.subject,
+		From_email:     addr.Address,
+		Reply_to:       *f.replyTo,
+		To_field:       *f.toField,
+		Attachment_url: *f.attachmentURL,
+	}
+	if *f.active {
+		t.Status = 1
+	}
+
+	return t, nil
+}
+
+func templatesCreate(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("templates create")
+	f := addTemplateFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "name", "subject", "from"); err != nil {
+		return err
+	}
+
+	t, err := f.template()
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.CreateTemplate(t)
+	if err != nil {
+		return err
+	}
+	return out.Print(resp)
+}
+
+func templatesUpdate(c *sib.Client, out *printer, args []string) error {
+
+	fs := newFlagSet("templates update")
+	id := fs.Int("id", 0, "template ID")
+	f := addTemplateFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := required(fs, "id", "name", "subject", "from"); err != nil {
+		return err
+	}
+
+	t, err := f.template()
+	if err != nil {
+		return err
+	}
+
+	// the status is always sent, so keep the current one unless -active
+	// was given
+	if !flagPassed(fs, "active") {
+		resp, err := c.GetTemplate(*id)
+		if err != nil {
+			return err
+		}
+		if len(resp.Data) == 0 {
+			return fmt.Errorf("template %d not found: %s", *id, resp.Message)
+		}
+		switch strings.ToLower(resp.Data[0].Templ_status) {
+		case "1", "active", "true":
+			t.Status = 1
+		default:
+			t.Status = 0
+		}
+	}
+
+	err = c.UpdateTemplate(*id, t)
+	if err != nil {
+		return err
+	}
+	return out.Message(fmt.Sprintf("template %d updated", *id))
+}
+
+// flagPassed reports whether the named flag was set on the command line.
+func flagPassed(fs *flag.FlagSet, name string) bool {
+	passed := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTemplatesUpdateKeepsStatus(t *testing.T) {
+
+	var sent string
+	c, _ := sib.NewClient("123")
+	c.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := `{"code":"success","data":[{"id":3,"templ_status":"Active"}]}`
+		if r.Method == "PUT" {
+			b, _ := ioutil.ReadAll(r.Body)
+			sent = string(b)
+			body = `{"code":"success"}`
+		}
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+
+	var buf bytes.Buffer
+	out, _ := newPrinter(&buf, "table")
+	args := []string{"-id", "3", "-name", "n", "-subject", "s", "-from", "a@example.com", "-html", "<p>x</p>"}
+
+	if err := templatesUpdate(c, out, args); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent, `"status":1`) {
+		t.Errorf("Updating a template without -active is changing its status: %s", sent)
+	}
+
+	if err := templatesUpdate(c, out, append(args, "-active=false")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent, `"status":0`) {
+		t.Errorf("-active=false is not deactivating the template: %s", sent)
+	}
+}
@@ -0,0 +1,75 @@
+package sib
+
+// An HTMLFilter rewrites HTML content on its way to the API.
+type HTMLFilter func(html string) (string, error)
+
+// WithHTMLFilter adds a filter applied, in the order added and before
+// any link rewriting and linting, to
+// Email.HTML in SendEmail and to Template.Html_content in CreateTemplate
+// and UpdateTemplate. The caller's values are not modified.
+func WithHTMLFilter(f HTMLFilter) Option {
+	return func(c *Client) {
+		c.htmlFilters = append(c.htmlFilters, f)
+	}
+}
+
+// WithInlineCSS inlines style sheets before sending; see InlineCSS.
+func WithInlineCSS() Option {
+	return WithHTMLFilter(func(s string) (string, error) {
+		return InlineCSS(s), nil
+	})
+}
+
+// filterEmail returns e, or a filtered copy of it.
+func (c *Client) filterEmail(e *Email) (*Email, error) {
+
+	if (len(c.htmlFilters) == 0 && len(c.linkParams) == 0 && c.lint == nil) || e.HTML == "" {
+		return e, nil
+	}
+
+	filtered := *e
+	for _, f := range c.htmlFilters {
+		var err error
+		filtered.HTML, err = f(filtered.HTML)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(c.linkParams) > 0 {
+		filtered.HTML = RewriteLinks(filtered.HTML, c.linkParams.tagged(e.Headers[TagHeader]))
+	}
+	if c.lint != nil {
+		if err := c.lint.check(filtered.HTML); err != nil {
+			return nil, err
+		}
+	}
+
+	return &filtered, nil
+}
+
+// filterTemplate returns t, or a filtered copy of it.
+func (c *Client) filterTemplate(t *Template) (*Template, error) {
+
+	if (len(c.htmlFilters) == 0 && len(c.linkParams) == 0 && c.lint == nil) || t.Html_content == "" {
+		return t, nil
+	}
+
+	filtered := *t
+	for _, f := range c.htmlFilters {
+		var err error
+		filtered.Html_content, err = f(filtered.Html_content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(c.linkParams) > 0 {
+		filtered.Html_content = RewriteLinks(filtered.Html_content, c.linkParams.tagged(t.Template_name))
+	}
+	if c.lint != nil {
+		if err := c.lint.check(filtered.Html_content); err != nil {
+			return nil, err
+		}
+	}
+
+	return &filtered, nil
+}
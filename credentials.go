@@ -0,0 +1,120 @@
+package sib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CredentialsProvider supplies the API key. It is consulted on every
+// request, so keys can be rotated without restarting the process.
+// Implementations must be safe for concurrent use.
+type CredentialsProvider interface {
+	APIKey() (string, error)
+}
+
+// WithCredentials makes the Client ask p for the API key on every request
+// instead of using the key given to NewClient.
+func WithCredentials(p CredentialsProvider) Option {
+	return func(c *Client) {
+		c.credentials = p
+	}
+}
+
+// StaticCredentials always returns the same key.
+type StaticCredentials string
+
+// APIKey implements CredentialsProvider.
+func (s StaticCredentials) APIKey() (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("No static API key")
+	}
+	return string(s), nil
+}
+
+// EnvCredentials reads the key from the named environment variable on
+// every request.
+type EnvCredentials string
+
+// APIKey implements CredentialsProvider.
+func (e EnvCredentials) APIKey() (string, error) {
+	key := strings.TrimSpace(os.Getenv(string(e)))
+	if key == "" {
+		return "", fmt.Errorf("Environment variable %s is not set", string(e))
+	}
+	return key, nil
+}
+
+// FileCredentials reads the key from a file, such as a mounted secret,
+// and re-reads it whenever the file's size or modification time changes.
+// Surrounding whitespace is ignored.
+type FileCredentials struct {
+	path string
+
+	mu      sync.Mutex
+	key     string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileCredentials returns a provider for the key stored at path.
+func NewFileCredentials(path string) *FileCredentials {
+	return &FileCredentials{path: path}
+}
+
+// APIKey implements CredentialsProvider.
+func (f *FileCredentials) APIKey() (string, error) {
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		err = fmt.Errorf("Could not read API key file: %+v", err)
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.key != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.key, nil
+	}
+
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		err = fmt.Errorf("Could not read API key file: %+v", err)
+		return "", err
+	}
+
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		err = fmt.Errorf("API key file %s is empty", f.path)
+		return "", err
+	}
+
+	f.key, f.modTime, f.size = key, info.ModTime(), info.Size()
+
+	return key, nil
+}
+
+// ChainCredentials tries each provider in turn and returns the first key
+// found. If none has a key, the errors of all providers are reported.
+type ChainCredentials []CredentialsProvider
+
+// APIKey implements CredentialsProvider.
+func (c ChainCredentials) APIKey() (string, error) {
+
+	var errs []string
+	for _, p := range c {
+		key, err := p.APIKey()
+		if err == nil && key != "" {
+			return key, nil
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	return "", fmt.Errorf("No API key in credentials chain: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,80 @@
+package sib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientCredentials(t *testing.T) {
+
+	if _, err := NewClient("", WithCredentials(EnvCredentials("SIB_TEST_KEY"))); err != nil {
+		t.Errorf("Expected a credentials provider to stand in for the API key: %v", err)
+	}
+
+	os.Setenv("SIB_TEST_KEY", "first")
+	defer os.Unsetenv("SIB_TEST_KEY")
+
+	client, _ := NewClient("", WithCredentials(EnvCredentials("SIB_TEST_KEY")))
+
+	var got string
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header.Get("api-key")
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	client.SendSMS(&SMSRequest{})
+	if got != "first" {
+		t.Errorf("Expected the provider's key, got %q", got)
+	}
+
+	os.Setenv("SIB_TEST_KEY", "second")
+	client.UpdateTemplate(1, &Template{})
+	if got != "second" {
+		t.Errorf("Expected the rotated key, got %q", got)
+	}
+
+	os.Unsetenv("SIB_TEST_KEY")
+	if _, err := client.SendSMS(&SMSRequest{}); err == nil {
+		t.Error("Expected a request without a key to fail.")
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "credentials")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+
+	f := NewFileCredentials(path)
+	if _, err := f.APIKey(); err == nil {
+		t.Error("Expected a missing key file to fail.")
+	}
+
+	ioutil.WriteFile(path, []byte("old-key\n"), 0600)
+	if key, _ := f.APIKey(); key != "old-key" {
+		t.Errorf("Expected old-key, got %q", key)
+	}
+
+	ioutil.WriteFile(path, []byte("new-key-2\n"), 0600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	if key, _ := f.APIKey(); key != "new-key-2" {
+		t.Errorf("Expected the file to be re-read after a change, got %q", key)
+	}
+}
+
+func TestChainCredentials(t *testing.T) {
+
+	chain := ChainCredentials{EnvCredentials("SIB_TEST_UNSET"), StaticCredentials("fallback")}
+	if key, err := chain.APIKey(); key != "fallback" || err != nil {
+		t.Errorf("Expected the fallback key, got %q %v", key, err)
+	}
+
+	chain = ChainCredentials{EnvCredentials("SIB_TEST_UNSET"), StaticCredentials("")}
+	if _, err := chain.APIKey(); err == nil {
+		t.Error("Expected an empty chain to fail.")
+	}
+}
@@ -0,0 +1,495 @@
+package sib
+
+import (
+	"strings"
+)
+
+// A minimal CSS parser and selector engine for InlineCSS. It handles
+// rule sets with type, universal, class, id and attribute selectors
+// joined by descendant, child and sibling combinators. Anything else,
+// such as pseudo-classes and at-rules, is reported as not inlinable and
+// kept as written.
+
+type cssDecl struct {
+	prop, value string
+	important   bool
+}
+
+type cssRule struct {
+	selectors []string
+	decls     []cssDecl
+}
+
+// cssSheet is a parsed style sheet: inlinable rule sets and the source
+// of everything else, in order.
+type cssSheet struct {
+	rules []cssRule
+	kept  []string
+}
+
+func parseCSS(s string) cssSheet {
+
+	var sheet cssSheet
+	s = stripCSSComments(s)
+
+	for i := 0; i < len(s); {
+		for i < len(s) && (isHTMLSpace(s[i]) || s[i] == ';') {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+
+		if s[i] == '@' {
+			end := i
+			for end < len(s) && s[end] != '{' && s[end] != ';' {
+				end++
+			}
+			if end < len(s) && s[end] == '{' {
+				end = matchBrace(s, end)
+			}
+			if end < len(s) {
+				end++
+			}
+			sheet.kept = append(sheet.kept, strings.TrimSpace(s[i:end]))
+			i = end
+			continue
+		}
+
+		open := strings.IndexByte(s[i:], '{')
+		if open < 0 {
+			break
+		}
+		open += i
+		close := matchBrace(s, open)
+		selectors := strings.Split(s[i:open], ",")
+		body := s[open+1 : close]
+		i = close + 1
+
+		rule := cssRule{decls: parseDecls(body)}
+		var keep []string
+		for _, sel := range selectors {
+			sel = strings.Join(strings.Fields(sel), " ")
+			if sel == "" {
+				continue
+			}
+			if _, ok := parseSelector(sel); ok {
+				rule.selectors = append(rule.selectors, sel)
+			} else {
+				keep = append(keep, sel)
+			}
+		}
+		if len(rule.selectors) > 0 {
+			sheet.rules = append(sheet.rules, rule)
+		}
+		if len(keep) > 0 {
+			sheet.kept = append(sheet.kept, strings.Join(keep, ", ")+" {"+strings.TrimRight(body, " \t\r\n")+" }")
+		}
+	}
+
+	return sheet
+}
+
+func stripCSSComments(s string) string {
+	for {
+		start := strings.Index(s, "/*")
+		if start < 0 {
+			return s
+		}
+		end := strings.Index(s[start+2:], "*/")
+		if end < 0 {
+			return s[:start]
+		}
+		s = s[:start] + s[start+2+end+2:]
+	}
+}
+
+// matchBrace returns the index of the brace closing s[open], or the end
+// of s.
+func matchBrace(s string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// parseDecls parses "prop: value; ..." as found in rule bodies and
+// style attributes.
+func parseDecls(s string) []cssDecl {
+
+	var decls []cssDecl
+	var quote byte
+	parens, start := 0, 0
+
+	add := func(d string) {
+		i := strings.IndexByte(d, ':')
+		if i < 0 {
+			return
+		}
+		decl := cssDecl{
+			prop:  strings.ToLower(strings.TrimSpace(d[:i])),
+			value: strings.TrimSpace(d[i+1:]),
+		}
+		if j := strings.LastIndexByte(decl.value, '!'); j >= 0 && strings.EqualFold(strings.TrimSpace(decl.value[j+1:]), "important") {
+			decl.value, decl.important = strings.TrimSpace(decl.value[:j]), true
+		}
+		if decl.prop != "" && decl.value != "" {
+			decls = append(decls, decl)
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			parens++
+		case c == ')':
+			parens--
+		case c == ';' && parens <= 0:
+			add(s[start:i])
+			start = i + 1
+		}
+	}
+	add(s[start:])
+
+	return decls
+}
+
+func formatDecls(decls []cssDecl) string {
+	parts := make([]string, len(decls))
+	for i, d := range decls {
+		parts[i] = d.prop + ": " + d.value
+		if d.important {
+			parts[i] += " !important"
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// A selector is a chain of compound selectors, rightmost first, each
+// with the combinator joining it to the next one to the left.
+type cssSelector []cssCompound
+
+type cssCompound struct {
+	tag        string // "" or "*" for any
+	id         string
+	classes    []string
+	attrs      []cssAttrMatch
+	combinator byte // ' ', '>', '+' or '~' towards the next compound; 0 for the last
+}
+
+type cssAttrMatch struct {
+	key, op, val string
+}
+
+func parseSelector(s string) (cssSelector, bool) {
+
+	var chain []cssCompound
+	var comb byte
+	i := 0
+
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ':
+			if comb == 0 {
+				comb = ' '
+			}
+			i++
+			continue
+		case c == '>' || c == '+' || c == '~':
+			comb = c
+			i++
+			continue
+		}
+
+		if len(chain) > 0 && comb == 0 {
+			return nil, false
+		}
+		if len(chain) == 0 && comb != 0 && comb != ' ' {
+			return nil, false
+		}
+
+		comp, n, ok := parseCompound(s[i:])
+		if !ok {
+			return nil, false
+		}
+		if len(chain) > 0 {
+			chain[len(chain)-1].combinator = comb
+		}
+		chain = append(chain, comp)
+		comb = 0
+		i += n
+	}
+	if len(chain) == 0 || (comb != 0 && comb != ' ') {
+		return nil, false
+	}
+
+	// reverse to match from the subject element outwards
+	sel := make(cssSelector, len(chain))
+	for i, c := range chain {
+		sel[len(chain)-1-i] = c
+	}
+	for i := range sel {
+		if i+1 < len(sel) {
+			sel[i].combinator = sel[i+1].combinator
+		} else {
+			sel[i].combinator = 0
+		}
+	}
+
+	return sel, true
+}
+
+func parseCompound(s string) (cssCompound, int, bool) {
+
+	var c cssCompound
+	i := 0
+
+	ident := func() string {
+		j := i
+		for j < len(s) && (isASCIILetter(s[j]) || s[j] >= '0' && s[j] <= '9' || s[j] == '-' || s[j] == '_' || s[j] >= 0x80) {
+			j++
+		}
+		id := s[i:j]
+		i = j
+		return id
+	}
+
+	if i < len(s) && s[i] == '*' {
+		c.tag = "*"
+		i++
+	} else if i < len(s) && isASCIILetter(s[i]) {
+		c.tag = strings.ToLower(ident())
+	}
+
+	for i < len(s) {
+		switch s[i] {
+		case '#':
+			i++
+			if c.id = ident(); c.id == "" {
+				return c, 0, false
+			}
+		case '.':
+			i++
+			class := ident()
+			if class == "" {
+				return c, 0, false
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return c, 0, false
+			}
+			m, ok := parseAttrMatch(s[i+1 : i+end])
+			if !ok {
+				return c, 0, false
+			}
+			c.attrs = append(c.attrs, m)
+			i += end + 1
+		case ' ', '>', '+', '~':
+			return c, i, i > 0
+		default:
+			// pseudo-classes, pseudo-elements and anything unknown
+			return c, 0, false
+		}
+	}
+
+	return c, i, i > 0
+}
+
+func parseAttrMatch(s string) (cssAttrMatch, bool) {
+
+	s = strings.TrimSpace(s)
+	for _, op := range []string{"~=", "^=", "$=", "*=", "|=", "="} {
+		if i := strings.Index(s, op); i > 0 {
+			val := strings.TrimSpace(s[i+len(op):])
+			if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+				val = val[1 : len(val)-1]
+			}
+			return cssAttrMatch{key: strings.ToLower(strings.TrimSpace(s[:i])), op: op, val: val}, true
+		}
+	}
+	if s == "" || strings.ContainsAny(s, " \"'") {
+		return cssAttrMatch{}, false
+	}
+
+	return cssAttrMatch{key: strings.ToLower(s)}, true
+}
+
+// specificity returns the selector's (ids, classes, types) weight as a
+// single comparable number.
+func (sel cssSelector) specificity() int {
+	a, b, c := 0, 0, 0
+	for _, comp := range sel {
+		if comp.id != "" {
+			a++
+		}
+		b += len(comp.classes) + len(comp.attrs)
+		if comp.tag != "" && comp.tag != "*" {
+			c++
+		}
+	}
+	return a<<16 | b<<8 | c
+}
+
+// cssElement is an element of the tree InlineCSS matches against. The
+// root of the tree has no token.
+type cssElement struct {
+	tok      *htmlToken
+	parent   *cssElement
+	children []*cssElement
+	index    int // position among the parent's children
+}
+
+func (sel cssSelector) matches(e *cssElement) bool {
+	m := &cssMatcher{sel: sel}
+	return m.match(0, e)
+}
+
+// cssMatcher matches one selector. It remembers the (component, element)
+// pairs that failed, since the ' ' and '~' combinators would otherwise
+// retry them along every path, which is exponential in the selector's
+// length.
+type cssMatcher struct {
+	sel    cssSelector
+	failed map[cssMatchKey]bool
+}
+
+type cssMatchKey struct {
+	i int
+	e *cssElement
+}
+
+func (m *cssMatcher) match(i int, e *cssElement) bool {
+
+	key := cssMatchKey{i, e}
+	if m.failed[key] {
+		return false
+	}
+	if m.matchFrom(i, e) {
+		return true
+	}
+	if m.failed == nil {
+		m.failed = make(map[cssMatchKey]bool)
+	}
+	m.failed[key] = true
+
+	return false
+}
+
+func (m *cssMatcher) matchFrom(i int, e *cssElement) bool {
+
+	sel := m.sel
+	if !sel[i].matches(e) {
+		return false
+	}
+	if i+1 == len(sel) {
+		return true
+	}
+
+	switch sel[i].combinator {
+	case '>':
+		return e.parent != nil && m.match(i+1, e.parent)
+	case ' ':
+		for p := e.parent; p != nil; p = p.parent {
+			if m.match(i+1, p) {
+				return true
+			}
+		}
+	case '+':
+		return e.index > 0 && m.match(i+1, e.parent.children[e.index-1])
+	case '~':
+		for j := e.index - 1; j >= 0; j-- {
+			if m.match(i+1, e.parent.children[j]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *cssCompound) matches(e *cssElement) bool {
+
+	t := e.tok
+	if t == nil {
+		// the document root
+		return false
+	}
+	if c.tag != "" && c.tag != "*" && c.tag != t.name {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := t.attr("id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := t.attr("class")
+		have := strings.Fields(class)
+		for _, want := range c.classes {
+			if !containsString(have, want) {
+				return false
+			}
+		}
+	}
+	for _, m := range c.attrs {
+		v, ok := t.attr(m.key)
+		if !ok {
+			return false
+		}
+		switch m.op {
+		case "=":
+			ok = v == m.val
+		case "~=":
+			ok = containsString(strings.Fields(v), m.val)
+		case "^=":
+			ok = m.val != "" && strings.HasPrefix(v, m.val)
+		case "$=":
+			ok = m.val != "" && strings.HasSuffix(v, m.val)
+		case "*=":
+			ok = m.val != "" && strings.Contains(v, m.val)
+		case "|=":
+			ok = v == m.val || strings.HasPrefix(v, m.val+"-")
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
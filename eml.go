@@ -0,0 +1,237 @@
+package sib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+var wordDecoder = new(mime.WordDecoder)
+
+// ReadEmail parses an RFC 5322 message, such as an .eml file, into an
+// Email ready for SendEmail; see EmailFromMessage.
+func ReadEmail(r io.Reader) (*Email, error) {
+
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		err = fmt.Errorf("Could not read message: %+v", err)
+		return nil, err
+	}
+
+	return EmailFromMessage(msg)
+}
+
+// EmailFromMessage converts a parsed message into an Email. Multipart
+// bodies are walked and quoted-printable and base64 parts decoded: the
+// first text/plain and text/html parts become Text and HTML, parts with a
+// Content-ID become Inline_image entries referenced from the HTML as
+// {{{name}}}, and other named parts become Attachments. Unnamed parts
+// other than the text and HTML bodies are kept as attachments named
+// part-N with an extension for their type. X- headers and
+// List-Unsubscribe are kept. Bcc recipients are not part of a message
+// and must be added by the caller.
+func EmailFromMessage(msg *mail.Message) (*Email, error) {
+
+	e := NewEmail()
+
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return nil, fmt.Errorf("Message has no valid From header")
+	}
+	e.From = [2]string{from[0].Address, from[0].Name}
+
+	if list, err := msg.Header.AddressList("Reply-To"); err == nil && len(list) > 0 {
+		e.ReplyTo = [2]string{list[0].Address, list[0].Name}
+	}
+
+	for _, h := range []struct {
+		name string
+		to   map[string]string
+	}{{"To", e.To}, {"Cc", e.CC}} {
+		list, err := msg.Header.AddressList(h.name)
+		if err != nil && err != mail.ErrHeaderNotPresent {
+			err = fmt.Errorf("Could not parse %s header: %+v", h.name, err)
+			return nil, err
+		}
+		for _, a := range list {
+			h.to[a.Address] = a.Name
+		}
+	}
+
+	e.Subject, err = wordDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		e.Subject = msg.Header.Get("Subject")
+	}
+
+	for k := range msg.Header {
+		if strings.HasPrefix(k, "X-") || k == "List-Unsubscribe" {
+			e.Headers[k] = msg.Header.Get(k)
+		}
+	}
+
+	cids := make(map[string]string)
+	err = walkPart(e, textproto.MIMEHeader(msg.Header), msg.Body, cids)
+	if err != nil {
+		return nil, err
+	}
+	for cid, name := range cids {
+		e.HTML = strings.Replace(e.HTML, "cid:"+cid, "{{{"+name+"}}}", -1)
+	}
+
+	return e, nil
+}
+
+// walkPart decodes one MIME entity into e, recursing into multiparts.
+// Content-IDs of inline images are recorded in cids.
+func walkPart(e *Email, h textproto.MIMEHeader, body io.Reader, cids map[string]string) error {
+
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				err = fmt.Errorf("Could not read %s part: %+v", mediaType, err)
+				return err
+			}
+			err = walkPart(e, part.Header, part, cids)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		err = fmt.Errorf("Could not decode %s part: %+v", mediaType, err)
+		return err
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if name, err := wordDecoder.DecodeHeader(filename); err == nil {
+		filename = name
+	}
+	cid := strings.Trim(h.Get("Content-Id"), "<> ")
+
+	switch {
+	case cid != "" && disposition != "attachment":
+		name := filename
+		if name == "" {
+			name = cid
+		}
+		name = uniqueName(e.Inline_image, name)
+		e.Inline_image[name] = base64.StdEncoding.EncodeToString(data)
+		cids[cid] = name
+	case disposition == "attachment" || filename != "":
+		if filename == "" {
+			filename = "attachment"
+		}
+		e.Attachment[uniqueName(e.Attachment, filename)] = base64.StdEncoding.EncodeToString(data)
+	case mediaType == "text/html" && e.HTML == "":
+		e.HTML = decodeCharset(params["charset"], data)
+	case mediaType == "text/plain" && e.Text == "":
+		e.Text = decodeCharset(params["charset"], data)
+	default:
+		// parts past the first text and HTML ones are kept, not dropped
+		name := fmt.Sprintf("part-%d%s", len(e.Attachment)+1, extensionByType(mediaType))
+		e.Attachment[uniqueName(e.Attachment, name)] = base64.StdEncoding.EncodeToString(data)
+	}
+
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// extensionByType returns a file extension for a media type, preferring
+// the one named after the subtype, or .bin when none is known. Plain text
+// gets .txt, which system tables may not list first.
+func extensionByType(mediaType string) string {
+
+	if mediaType == "text/plain" {
+		return ".txt"
+	}
+
+	exts, _ := mime.ExtensionsByType(mediaType)
+	if len(exts) == 0 {
+		return ".bin"
+	}
+
+	sub := "." + mediaType[strings.IndexByte(mediaType, '/')+1:]
+	for _, ext := range exts {
+		if ext == sub {
+			return ext
+		}
+	}
+
+	return exts[0]
+}
+
+// windows1252 maps the bytes 0x80 to 0x9F, where windows-1252 differs
+// from latin-1. Unassigned bytes map to the latin-1 control characters.
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// decodeCharset converts latin-1 and windows-1252 text to UTF-8, even
+// when the bytes happen to be valid UTF-8. Other charsets are assumed to
+// be UTF-8 compatible.
+func decodeCharset(charset string, data []byte) string {
+
+	charset = strings.ToLower(charset)
+	switch charset {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		var b bytes.Buffer
+		for _, c := range data {
+			if charset == "windows-1252" || charset == "cp1252" {
+				if c >= 0x80 && c <= 0x9f {
+					b.WriteRune(windows1252[c-0x80])
+					continue
+				}
+			}
+			b.WriteRune(rune(c))
+		}
+		return b.String()
+	}
+
+	return string(data)
+}
+
+func uniqueName(m map[string]string, name string) string {
+	if _, ok := m[name]; !ok {
+		return name
+	}
+	for i := 2; ; i++ {
+		n := fmt.Sprintf("%d-%s", i, name)
+		if _, ok := m[n]; !ok {
+			return n
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package sib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestReadEmail(t *testing.T) {
+
+	raw := "From: =?iso-8859-1?q?Jos=E9?= <jose@example.com>\r\n" +
+		"To: a@example.com, \"B\" <b@example.com>\r\n" +
+		"Cc: c@example.com\r\n" +
+		"Reply-To: help@example.com\r\n" +
+		"Subject: =?iso-8859-1?q?Caf=E9?=\r\n" +
+		"Received: from somewhere\r\n" +
+		"X-Campaign: spring\r\n" +
+		"Content-Type: text/html; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<p>Caf=E9 au lait, a very long line that has been soft wrapped by the q=\r\n" +
+		"p encoder</p>"
+
+	e, err := ReadEmail(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.From != [2]string{"jose@example.com", "José"} {
+		t.Errorf("From is not being decoded: %v", e.From)
+	}
+	if len(e.To) != 2 || e.To["b@example.com"] != "B" || len(e.CC) != 1 {
+		t.Errorf("Recipients are not being parsed: %v %v", e.To, e.CC)
+	}
+	if e.ReplyTo[0] != "help@example.com" {
+		t.Errorf("Reply-To is not being parsed: %v", e.ReplyTo)
+	}
+	if e.Subject != "Café" {
+		t.Errorf("Subject is not being decoded: %q", e.Subject)
+	}
+	if e.HTML != "<p>Café au lait, a very long line that has been soft wrapped by the qp encoder</p>" {
+		t.Errorf("Latin-1 quoted-printable HTML is not being decoded: %q", e.HTML)
+	}
+	if e.Headers["X-Campaign"] != "spring" || e.Headers["Received"] != "" {
+		t.Errorf("Headers are not being filtered: %v", e.Headers)
+	}
+
+	if _, err := ReadEmail(strings.NewReader("Subject: no sender\r\n\r\nbody")); err == nil {
+		t.Error("Messages without From are not being rejected.")
+	}
+}
+
+func TestReadEmailRoundTrip(t *testing.T) {
+
+	e := testRelayEmail()
+	e.Bcc = map[string]string{}
+	e.Attachment["photo.jpg"] = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xff, 0xd8, 0x00}, 100))
+
+	var buf bytes.Buffer
+	if err := e.WriteMIME(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadEmail(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Subject != e.Subject || got.Text != e.Text || got.To["to@example.com"] != "Tö Person" {
+		t.Errorf("Headers and text are not surviving a round trip: %+v", got)
+	}
+	if got.HTML != `<p>Hi</p><img src="{{{logo.png}}}">` {
+		t.Errorf("Inline image references are not surviving a round trip: %q", got.HTML)
+	}
+	if got.Inline_image["logo.png"] != e.Inline_image["./test/logo.png"] {
+		t.Errorf("Inline images are not surviving a round trip: %v", got.Inline_image)
+	}
+	for name, data := range e.Attachment {
+		if got.Attachment[name] != data {
+			t.Errorf("Attachment %s is not surviving a round trip.", name)
+		}
+	}
+	if got.Headers["X-Campaign"] != "spring" {
+		t.Errorf("Custom headers are not surviving a round trip: %v", got.Headers)
+	}
+}
+
+func TestReadEmailUnnamedParts(t *testing.T) {
+
+	raw := "From: a@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=windows-1252\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"=93Caf=E9=94 costs =803\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"\r\n" +
+		"%PDF\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/x-unknown-type\r\n" +
+		"\r\n" +
+		"data\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"second\r\n" +
+		"--b--\r\n"
+
+	e, err := ReadEmail(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Text != "“Café” costs €3" {
+		t.Errorf("Windows-1252 text is not being decoded: %q", e.Text)
+	}
+	if b, _ := base64.StdEncoding.DecodeString(e.Attachment["part-1.pdf"]); string(b) != "%PDF" {
+		t.Errorf("Unnamed parts are not being kept: %v", e.Attachment)
+	}
+	if _, ok := e.Attachment["part-2.bin"]; !ok {
+		t.Errorf("Unnamed parts of unknown type are not being kept: %v", e.Attachment)
+	}
+	if b, _ := base64.StdEncoding.DecodeString(e.Attachment["part-3.txt"]); string(b) != "second" {
+		t.Errorf("A second text part is being dropped: %v", e.Attachment)
+	}
+}
+
+func TestReadEmailLatin1(t *testing.T) {
+
+	raw := "From: a@example.com\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"=C3=A9"
+
+	e, err := ReadEmail(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Text != "Ã©" {
+		t.Errorf("Latin-1 text that is valid UTF-8 is not being decoded: %q", e.Text)
+	}
+}
@@ -0,0 +1,287 @@
+package sib
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// blockElements start and end a paragraph in the text version.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"center": true, "div": true, "dl": true, "dt": true, "dd": true,
+	"fieldset": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+	"hr": true, "main": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+// droppedElements have no text version.
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "title": true,
+	"noscript": true, "template": true,
+}
+
+// headElements may appear in the head. Any other element ends a head
+// whose optional end tag was left out.
+var headElements = map[string]bool{
+	"base": true, "link": true, "meta": true, "noscript": true,
+	"script": true, "style": true, "template": true, "title": true,
+}
+
+// HTMLToText converts email HTML into a readable plain-text alternative.
+// Style, script and head content is dropped, blocks become paragraphs,
+// list items become bullets (or numbers in ordered lists), images are
+// replaced by their alt text, and links are kept as numbered footnotes.
+// Placeholders such as %NAME% and {{ params.NAME }} pass through.
+func HTMLToText(s string) string {
+
+	w := &textWriter{}
+	links := []string{}
+	var lists []int // per open list: -1 for bullets, else the next number
+	var hrefs []string
+	drop, pre := 0, 0
+	head := false
+
+	for _, t := range tokenizeHTML(s) {
+		switch t.typ {
+		case textToken:
+			if drop > 0 || head {
+				continue
+			}
+			if pre > 0 {
+				w.writeRaw(html.UnescapeString(t.raw))
+			} else {
+				w.writeText(html.UnescapeString(t.raw))
+			}
+
+		case startTagToken:
+			if t.name == "head" {
+				head = !t.selfClosing
+				continue
+			}
+			if head && !headElements[t.name] {
+				head = false
+			}
+			if droppedElements[t.name] {
+				if !t.selfClosing {
+					drop++
+				}
+				continue
+			}
+			if drop > 0 {
+				continue
+			}
+			switch t.name {
+			case "br":
+				w.newline()
+			case "pre":
+				pre++
+			case "ul", "ol":
+				// nested lists continue their parent item
+				if len(lists) > 0 {
+					w.newline()
+					lists = append(lists, listStart(t.name))
+					continue
+				}
+				lists = append(lists, listStart(t.name))
+			case "li":
+				w.newline()
+				if len(lists) == 0 {
+					w.writeRaw("* ")
+					continue
+				}
+				w.indent = strings.Repeat("  ", len(lists)-1)
+				if n := lists[len(lists)-1]; n < 0 {
+					w.writeRaw("* ")
+				} else {
+					w.writeRaw(fmt.Sprintf("%d. ", n))
+					lists[len(lists)-1]++
+				}
+				w.indent += "  "
+				continue
+			case "td", "th":
+				w.space()
+			case "img":
+				if alt, _ := t.attr("alt"); strings.TrimSpace(alt) != "" {
+					w.writeText(alt)
+				}
+			case "a":
+				href, _ := t.attr("href")
+				hrefs = append(hrefs, strings.TrimSpace(href))
+				w.linkStart = w.b.Len()
+			}
+			if blockElements[t.name] {
+				w.paragraph()
+			}
+
+		case endTagToken:
+			if t.name == "head" {
+				head = false
+				continue
+			}
+			if droppedElements[t.name] {
+				if drop > 0 {
+					drop--
+				}
+				continue
+			}
+			if drop > 0 {
+				continue
+			}
+			switch t.name {
+			case "pre":
+				if pre > 0 {
+					pre--
+				}
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				w.indent = strings.Repeat("  ", len(lists))
+				if len(lists) > 0 {
+					w.newline()
+					continue
+				}
+			case "li":
+				w.newline()
+			case "a":
+				if len(hrefs) == 0 {
+					continue
+				}
+				href := hrefs[len(hrefs)-1]
+				hrefs = hrefs[:len(hrefs)-1]
+				text := strings.TrimSpace(w.b.String()[w.linkStart:])
+				if footnote(href, text) {
+					links = append(links, href)
+					w.writeRaw(fmt.Sprintf(" [%d]", len(links)))
+				}
+			}
+			if blockElements[t.name] {
+				w.paragraph()
+			}
+		}
+	}
+
+	out := w.String()
+	if len(links) > 0 {
+		var b strings.Builder
+		b.WriteString(out + "\n\n")
+		for i, l := range links {
+			fmt.Fprintf(&b, "[%d] %s\n", i+1, l)
+		}
+		out = strings.TrimRight(b.String(), "\n")
+	}
+
+	return out
+}
+
+// GenerateText sets e.Text to the plain-text version of e.HTML when
+// e.Text is empty; see HTMLToText.
+func (e *Email) GenerateText() {
+	if e.Text == "" && e.HTML != "" {
+		e.Text = HTMLToText(e.HTML)
+	}
+}
+
+// WithGeneratedText makes SendEmail send a plain-text version generated
+// from the HTML for emails that only set HTML. The caller's Email is not
+// modified.
+func WithGeneratedText() Option {
+	return func(c *Client) {
+		c.generateText = true
+	}
+}
+
+func listStart(name string) int {
+	if name == "ol" {
+		return 1
+	}
+	return -1
+}
+
+// footnote reports whether a link should be listed: in-page anchors,
+// javascript and links whose text is already the address are not.
+func footnote(href, text string) bool {
+	switch {
+	case href == "", strings.HasPrefix(href, "#"), strings.HasPrefix(strings.ToLower(href), "javascript:"):
+		return false
+	case href == text, strings.TrimPrefix(href, "mailto:") == text:
+		return false
+	}
+	return true
+}
+
+// textWriter collapses whitespace and tracks line and paragraph breaks.
+type textWriter struct {
+	b            strings.Builder
+	indent       string
+	linkStart    int
+	breaks       int // pending line breaks, 2 for a paragraph
+	pendingSpace bool
+}
+
+func (w *textWriter) flushBreaks() {
+	if w.b.Len() == 0 {
+		w.breaks, w.pendingSpace = 0, false
+		return
+	}
+	if w.breaks > 0 {
+		w.b.WriteString(strings.Repeat("\n", w.breaks))
+		w.b.WriteString(w.indent)
+		w.breaks, w.pendingSpace = 0, false
+	}
+	if w.pendingSpace {
+		w.b.WriteByte(' ')
+		w.pendingSpace = false
+	}
+}
+
+func (w *textWriter) writeText(s string) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			w.space()
+		}
+		return
+	}
+	if isHTMLSpace(s[0]) {
+		w.space()
+	}
+	w.flushBreaks()
+	w.b.WriteString(strings.Join(fields, " "))
+	if isHTMLSpace(s[len(s)-1]) {
+		w.space()
+	}
+}
+
+func (w *textWriter) writeRaw(s string) {
+	w.flushBreaks()
+	w.b.WriteString(s)
+}
+
+func (w *textWriter) space() {
+	if w.breaks == 0 {
+		w.pendingSpace = true
+	}
+}
+
+func (w *textWriter) newline() {
+	if w.breaks < 1 {
+		w.breaks = 1
+	}
+	w.pendingSpace = false
+}
+
+func (w *textWriter) paragraph() {
+	w.breaks = 2
+	w.pendingSpace = false
+}
+
+func (w *textWriter) String() string {
+	lines := strings.Split(w.b.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,111 @@
+package sib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+
+	in := `<html><head><title>Ignored</title><style>p { color: red }</style></head>
+<body>
+  <script>var x = "<p>no</p>";</script>
+  <h1>Hello   %FIRSTNAME%,</h1>
+  <p>Your order is <b>ready</b>.<br>Pick it up &amp; enjoy.</p>
+  <ul>
+    <li>Soup</li>
+    <li>Bread<ol><li>Rye</li><li>Wheat</li></ol></li>
+  </ul>
+  <p><a href="https://example.com/order?id=1&amp;x=2">View order</a>,
+  <a href="mailto:help@example.com">help@example.com</a>,
+  <a href="#top">top</a> or <a href="{{ params.UNSUB }}">unsubscribe</a>.</p>
+  <img src="logo.png" alt="Example Inc"><img src="spacer.gif">
+</body></html>`
+
+	want := `Hello %FIRSTNAME%,
+
+Your order is ready.
+Pick it up & enjoy.
+
+* Soup
+* Bread
+  1. Rye
+  2. Wheat
+
+View order [1], help@example.com, top or unsubscribe [2].
+
+Example Inc
+
+[1] https://example.com/order?id=1&x=2
+[2] {{ params.UNSUB }}`
+
+	got := HTMLToText(in)
+	if got != want {
+		t.Errorf("Unexpected text version:\n%s\n--- want ---\n%s", got, want)
+	}
+}
+
+func TestHTMLToTextOpenHead(t *testing.T) {
+
+	for in, want := range map[string]string{
+		`<head><title>x</title><body>Hi`:                 "Hi",
+		`<head><meta charset="utf-8"><p>Hi</p>`:          "Hi",
+		`<html><head><style>p{}</style></head>Hi</html>`: "Hi",
+	} {
+		if got := HTMLToText(in); got != want {
+			t.Errorf("A head without an end tag is swallowing the body: %q gives %q", in, got)
+		}
+	}
+}
+
+func TestTokenizeHTML(t *testing.T) {
+
+	s := `<!DOCTYPE html><a HREF='x?a=1&amp;b=2' data-x=y disabled>t</a><!-- <b> --><style>a>b{}</style><br/>`
+	var names []string
+	var raw strings.Builder
+	for _, tok := range tokenizeHTML(s) {
+		raw.WriteString(tok.raw)
+		if tok.typ == startTagToken {
+			names = append(names, tok.name)
+		}
+		if tok.name == "a" && tok.typ == startTagToken {
+			if href, _ := tok.attr("href"); href != "x?a=1&b=2" {
+				t.Errorf("Attribute values are not being unescaped: %q", href)
+			}
+			if len(tok.attrs) != 3 {
+				t.Errorf("Attributes are not being parsed: %v", tok.attrs)
+			}
+		}
+	}
+
+	if raw.String() != s {
+		t.Error("Tokens are not covering the source.")
+	}
+	if strings.Join(names, ",") != "a,style,br" {
+		t.Errorf("Comments or raw text are being parsed as tags: %v", names)
+	}
+}
+
+func TestClientGeneratedText(t *testing.T) {
+
+	var body string
+	client, _ := NewClient("123", WithGeneratedText())
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	e := NewEmail()
+	e.HTML = "<p>Hi there</p>"
+	client.SendEmail(e)
+
+	if !strings.Contains(body, `"text":"Hi there"`) {
+		t.Errorf("Text is not being generated: %s", body)
+	}
+	if e.Text != "" {
+		t.Error("The caller's email is being modified.")
+	}
+}
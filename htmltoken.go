@@ -0,0 +1,242 @@
+package sib
+
+import (
+	"html"
+	"strings"
+)
+
+// A small, forgiving HTML tokenizer for the content helpers. It does not
+// build a tree or fix up markup; it only splits the source into tokens
+// and keeps each token's original text so that unchanged parts can be
+// written back byte for byte.
+
+type htmlTokenType int
+
+const (
+	textToken htmlTokenType = iota
+	startTagToken
+	endTagToken
+	commentToken
+	doctypeToken
+)
+
+type htmlAttr struct {
+	key, val string
+}
+
+type htmlToken struct {
+	typ         htmlTokenType
+	name        string // lower-case tag name
+	attrs       []htmlAttr
+	selfClosing bool
+	raw         string
+	offset      int
+}
+
+// attr returns the unescaped value of the named attribute.
+func (t *htmlToken) attr(key string) (string, bool) {
+	for _, a := range t.attrs {
+		if a.key == key {
+			return a.val, true
+		}
+	}
+	return "", false
+}
+
+// setAttr sets or adds an attribute and re-renders raw.
+func (t *htmlToken) setAttr(key, val string) {
+	found := false
+	for i := range t.attrs {
+		if t.attrs[i].key == key {
+			t.attrs[i].val = val
+			found = true
+		}
+	}
+	if !found {
+		t.attrs = append(t.attrs, htmlAttr{key, val})
+	}
+	t.render()
+}
+
+var attrEscaper = strings.NewReplacer("&", "&amp;", `"`, "&quot;")
+
+func (t *htmlToken) render() {
+	var b strings.Builder
+	b.WriteString("<" + t.name)
+	for _, a := range t.attrs {
+		b.WriteString(" " + a.key)
+		if a.val != "" {
+			b.WriteString(`="` + attrEscaper.Replace(a.val) + `"`)
+		}
+	}
+	if t.selfClosing {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+	t.raw = b.String()
+}
+
+// voidElements never have an end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// rawTextElements hold unparsed text up to their end tag.
+var rawTextElements = map[string]bool{
+	"script": true, "style": true, "textarea": true, "title": true,
+}
+
+func tokenizeHTML(s string) []htmlToken {
+
+	var tokens []htmlToken
+	text := 0
+
+	flush := func(end int) {
+		if end > text {
+			tokens = append(tokens, htmlToken{typ: textToken, raw: s[text:end], offset: text})
+		}
+	}
+
+	for i := 0; i < len(s); {
+		if s[i] != '<' || i+1 >= len(s) {
+			i++
+			continue
+		}
+
+		var tok htmlToken
+		var n int
+		switch c := s[i+1]; {
+		case strings.HasPrefix(s[i:], "<!--"):
+			end := strings.Index(s[i+4:], "-->")
+			if end < 0 {
+				n = len(s) - i
+			} else {
+				n = end + 7
+			}
+			tok = htmlToken{typ: commentToken}
+		case c == '!' || c == '?':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			n = end + 1
+			tok = htmlToken{typ: doctypeToken}
+		case c == '/' && i+2 < len(s) && isASCIILetter(s[i+2]):
+			tok, n = parseTag(s[i:], 2)
+			tok.typ = endTagToken
+		case isASCIILetter(c):
+			tok, n = parseTag(s[i:], 1)
+			tok.typ = startTagToken
+		default:
+			i++
+			continue
+		}
+
+		flush(i)
+		tok.raw, tok.offset = s[i:i+n], i
+		tokens = append(tokens, tok)
+		i += n
+		text = i
+
+		if tok.typ == startTagToken && rawTextElements[tok.name] && !tok.selfClosing {
+			end := indexFold(s[i:], "</"+tok.name)
+			if end < 0 {
+				end = len(s) - i
+			}
+			i += end
+			flush(i)
+			text = i
+		}
+	}
+	flush(len(s))
+
+	return tokens
+}
+
+// parseTag parses the tag starting at s[0] == '<', with the name at
+// s[start], and returns it with its length.
+func parseTag(s string, start int) (htmlToken, int) {
+
+	var tok htmlToken
+	i := start
+	for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '>' && s[i] != '/' {
+		i++
+	}
+	tok.name = strings.ToLower(s[start:i])
+
+	for i < len(s) {
+		for i < len(s) && isHTMLSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		if s[i] == '>' {
+			return tok, i + 1
+		}
+		if s[i] == '/' {
+			if i+1 < len(s) && s[i+1] == '>' {
+				tok.selfClosing = true
+				return tok, i + 2
+			}
+			i++
+			continue
+		}
+
+		k := i
+		for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '=' && s[i] != '>' && (s[i] != '/' || i == k) {
+			i++
+		}
+		attr := htmlAttr{key: strings.ToLower(s[k:i])}
+
+		j := i
+		for j < len(s) && isHTMLSpace(s[j]) {
+			j++
+		}
+		if j < len(s) && s[j] == '=' {
+			i = j + 1
+			for i < len(s) && isHTMLSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				q := s[i]
+				end := strings.IndexByte(s[i+1:], q)
+				if end < 0 {
+					end = len(s) - i - 1
+				}
+				attr.val = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				v := i
+				for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				attr.val = s[v:i]
+			}
+			attr.val = html.UnescapeString(attr.val)
+		}
+		tok.attrs = append(tok.attrs, attr)
+	}
+
+	return tok, len(s)
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// indexFold is strings.Index ignoring ASCII case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
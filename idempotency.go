@@ -0,0 +1,284 @@
+package sib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long successful sends are remembered by
+// the store that is used when no WithIdempotencyStore option is given.
+var DefaultIdempotencyTTL = 24 * time.Hour
+
+// An IdempotencyStore remembers the responses of sends that succeeded,
+// keyed by the caller's idempotency key. Implementations must be safe for
+// concurrent use and expire keys after their TTL.
+type IdempotencyStore interface {
+	Load(key string) (response []byte, ok bool, err error)
+	Store(key string, response []byte) error
+}
+
+// WithIdempotencyStore sets the store used by the *Idempotent send methods.
+// A nil store leaves the default in-memory store in place.
+func WithIdempotencyStore(s IdempotencyStore) Option {
+	return func(c *Client) {
+		if s == nil {
+			c.idempotency = nil
+			return
+		}
+		c.idempotency = &idempotency{store: s}
+	}
+}
+
+// SendEmailIdempotent is SendEmail guarded by an idempotency key: when a
+// send with the same key already succeeded, the stored response is
+// returned and nothing is sent. Concurrent calls with the same key wait
+// for each other. Failed sends are not recorded and may be retried.
+func (c *Client) SendEmailIdempotent(key string, e *Email) (EmailResponse, error) {
+
+	var resp EmailResponse
+	err := c.idempotent("email:"+key, &resp, func() (interface{}, bool, error) {
+		r, err := c.SendEmail(e)
+		return r, r.Code == "success", err
+	})
+
+	return resp, err
+}
+
+// SendTemplateEmailIdempotent is SendTemplateEmail guarded by an
+// idempotency key, like SendEmailIdempotent.
+func (c *Client) SendTemplateEmailIdempotent(key string, id int, to []string, e *EmailOptions) (EmailResponse, error) {
+
+	var resp EmailResponse
+	err := c.idempotent("template:"+key, &resp, func() (interface{}, bool, error) {
+		r, err := c.SendTemplateEmail(id, to, e)
+		return r, r.Code == "success", err
+	})
+
+	return resp, err
+}
+
+// SendSMSIdempotent is SendSMS guarded by an idempotency key, like
+// SendEmailIdempotent.
+func (c *Client) SendSMSIdempotent(key string, s *SMSRequest) (SMSResponse, error) {
+
+	var resp SMSResponse
+	err := c.idempotent("sms:"+key, &resp, func() (interface{}, bool, error) {
+		r, err := c.SendSMS(s)
+		return r, r.Code == "success", err
+	})
+
+	return resp, err
+}
+
+type idempotency struct {
+	store IdempotencyStore
+
+	mu       sync.Mutex
+	inFlight map[string]chan struct{}
+}
+
+// idempotent decodes the stored response for key into resp, or calls
+// send and stores its response when it succeeded, even if archiving the
+// email then failed.
+func (c *Client) idempotent(key string, resp interface{}, send func() (interface{}, bool, error)) error {
+
+	c.idemOnce.Do(func() {
+		if c.idempotency == nil {
+			c.idempotency = &idempotency{store: NewMemoryIdempotencyStore(DefaultIdempotencyTTL)}
+		}
+	})
+	idem := c.idempotency
+
+	release := idem.acquire(key)
+	defer release()
+
+	b, ok, err := idem.store.Load(key)
+	if err != nil {
+		err = fmt.Errorf("Could not load idempotency key: %+v", err)
+		return err
+	}
+	if ok {
+		err = json.Unmarshal(b, resp)
+		if err != nil {
+			err = fmt.Errorf("Could not decode stored response: %+v", err)
+		}
+		return err
+	}
+
+	r, success, err := send()
+
+	b, marshalErr := json.Marshal(r)
+	if marshalErr == nil {
+		json.Unmarshal(b, resp)
+	}
+	// An ArchiveError comes after a send, which must not be repeated.
+	var archiveErr *ArchiveError
+	if (err != nil && !errors.As(err, &archiveErr)) || !success {
+		return err
+	}
+
+	if marshalErr == nil {
+		marshalErr = idem.store.Store(key, b)
+	}
+	if marshalErr != nil {
+		err = fmt.Errorf("Sent, but could not store idempotency key: %+v", marshalErr)
+		return err
+	}
+
+	return err
+}
+
+// acquire serialises calls that share a key; the returned func releases it.
+func (i *idempotency) acquire(key string) func() {
+
+	for {
+		i.mu.Lock()
+		if i.inFlight == nil {
+			i.inFlight = make(map[string]chan struct{})
+		}
+		wait, busy := i.inFlight[key]
+		if !busy {
+			done := make(chan struct{})
+			i.inFlight[key] = done
+			i.mu.Unlock()
+
+			return func() {
+				i.mu.Lock()
+				delete(i.inFlight, key)
+				i.mu.Unlock()
+				close(done)
+			}
+		}
+		i.mu.Unlock()
+
+		<-wait
+	}
+}
+
+type idempotencyEntry struct {
+	Response json.RawMessage `json:"response"`
+	Expires  time.Time       `json:"expires"`
+}
+
+// MemoryIdempotencyStore keeps idempotency keys in memory for a TTL.
+type MemoryIdempotencyStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+}
+
+// NewMemoryIdempotencyStore returns an in-memory store that forgets keys after ttl.
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]idempotencyEntry),
+	}
+}
+
+// Load implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Load(key string) ([]byte, bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(e.Expires) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+
+	return e.Response, true, nil
+}
+
+// Store implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Store(key string, response []byte) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range s.entries {
+		if now.After(e.Expires) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = idempotencyEntry{Response: response, Expires: now.Add(s.ttl)}
+
+	return nil
+}
+
+// FileIdempotencyStore keeps idempotency keys in a JSON file for a TTL,
+// so they survive restarts. The file is rewritten atomically on every
+// Store; it suits the volume of a single service, not a shared cluster.
+type FileIdempotencyStore struct {
+	mem  *MemoryIdempotencyStore
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileIdempotencyStore opens or creates the store at path.
+func NewFileIdempotencyStore(path string, ttl time.Duration) (*FileIdempotencyStore, error) {
+
+	s := &FileIdempotencyStore{
+		mem:  NewMemoryIdempotencyStore(ttl),
+		path: path,
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("Could not read idempotency store: %+v", err)
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &s.mem.entries)
+	if err != nil {
+		err = fmt.Errorf("Could not decode idempotency store: %+v", err)
+		return nil, err
+	}
+	if s.mem.entries == nil {
+		// the file held null
+		s.mem.entries = make(map[string]idempotencyEntry)
+	}
+
+	return s, nil
+}
+
+// Load implements IdempotencyStore.
+func (s *FileIdempotencyStore) Load(key string) ([]byte, bool, error) {
+	return s.mem.Load(key)
+}
+
+// Store implements IdempotencyStore.
+func (s *FileIdempotencyStore) Store(key string, response []byte) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.Store(key, response)
+
+	s.mem.mu.Lock()
+	b, err := json.Marshal(s.mem.entries)
+	s.mem.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+
+	return err
+}
@@ -0,0 +1,163 @@
+package sib
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendEmailIdempotent(t *testing.T) {
+
+	client, _ := NewClient("123")
+
+	var sends int32
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		n := atomic.AddInt32(&sends, 1)
+		time.Sleep(10 * time.Millisecond)
+		if n == 1 {
+			return jsonResponse(`{"code":"failure","message":"temporary"}`), nil
+		}
+		return jsonResponse(`{"code":"success","data":{"message-id":"<1@example.net>"}}`), nil
+	})
+
+	resp, err := client.SendEmailIdempotent("order-1", NewEmail())
+	if err != nil || resp.Code != "failure" {
+		t.Fatalf("Expected the failed response to be returned, got %+v %v", resp, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.SendEmailIdempotent("order-1", NewEmail())
+			if err != nil || resp.Data.Message_id != "<1@example.net>" {
+				t.Errorf("Expected the stored response, got %+v %v", resp, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sends != 2 {
+		t.Errorf("Expected a failed send to be retried once and then deduplicated, sent %d times", sends)
+	}
+
+	client.SendSMSIdempotent("order-1", &SMSRequest{})
+	if sends != 3 {
+		t.Error("Keys are not being namespaced per kind of send.")
+	}
+}
+
+func TestSendEmailIdempotentArchiveFailure(t *testing.T) {
+
+	client, _ := NewClient("123", WithArchive(failingArchive{}))
+
+	var sends int32
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&sends, 1)
+		return jsonResponse(`{"code":"success","data":{"message-id":"<1@example.net>"}}`), nil
+	})
+
+	e := NewEmail()
+	e.From = [2]string{"from@example.com", "From"}
+	_, err := client.SendEmailIdempotent("order-2", e)
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) {
+		t.Fatalf("Expected an ArchiveError, got %v", err)
+	}
+
+	resp, err := client.SendEmailIdempotent("order-2", e)
+	if err != nil || resp.Data.Message_id != "<1@example.net>" {
+		t.Errorf("Expected the stored response, got %+v %v", resp, err)
+	}
+	if sends != 1 {
+		t.Errorf("Emails that failed to archive are being sent again: sent %d times", sends)
+	}
+}
+
+func TestMemoryIdempotencyStoreTTL(t *testing.T) {
+
+	s := NewMemoryIdempotencyStore(20 * time.Millisecond)
+	s.Store("k", []byte(`{}`))
+
+	if _, ok, _ := s.Load("k"); !ok {
+		t.Error("Key is not being stored.")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok, _ := s.Load("k"); ok {
+		t.Error("Key is not expiring.")
+	}
+}
+
+func TestFileIdempotencyStore(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "idempotency")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	s, err := NewFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store("email:k", []byte(`{"code":"success"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok, _ := s.Load("email:k")
+	if !ok || string(b) != `{"code":"success"}` {
+		t.Errorf("Key is not surviving a reopen: %s %v", b, ok)
+	}
+
+	client, _ := NewClient("123", WithIdempotencyStore(s))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Error("A stored key must not be sent again.")
+		return jsonResponse(`{}`), nil
+	})
+	resp, _ := client.SendEmailIdempotent("k", NewEmail())
+	if resp.Code != "success" {
+		t.Errorf("Expected the stored response, got %+v", resp)
+	}
+}
+
+func TestFileIdempotencyStoreNull(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "idempotency")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	ioutil.WriteFile(path, []byte("null"), 0644)
+
+	s, err := NewFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store("email:k", []byte(`{"code":"success"}`)); err != nil {
+		t.Errorf("A store file holding null is not being accepted: %v", err)
+	}
+}
+
+func TestNilIdempotencyStore(t *testing.T) {
+
+	sends := 0
+	client, _ := NewClient("123", WithIdempotencyStore(nil))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sends++
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	client.SendSMSIdempotent("k", &SMSRequest{})
+	client.SendSMSIdempotent("k", &SMSRequest{})
+	if sends != 1 {
+		t.Errorf("A nil store is not falling back to memory: %d sends", sends)
+	}
+}
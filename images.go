@@ -0,0 +1,111 @@
+package sib
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var imgSrc = regexp.MustCompile(`(?i)(<img\b[^>]*?\bsrc\s*=\s*)("[^"]*"|'[^']*'|[^\s"'>]+)`)
+
+// EmbedImages embeds the local images referenced by <img src> in e.HTML
+// as inline images, the way AddImage does, and rewrites each src to the
+// {{{name}}} placeholder. Paths are read from fsys, or from the working
+// directory when fsys is nil; absolute paths start at its root and paths
+// leading out of it are an error. Remote, data: and cid: sources and
+// existing placeholders are left alone. An image used more than once,
+// under the same path or with the same content, is embedded once; names
+// are made unique when different images share a file name. On error e is
+// left unchanged.
+func (e *Email) EmbedImages(fsys fs.FS) error {
+
+	if fsys == nil {
+		fsys = os.DirFS(".")
+	}
+
+	images := copyMap(e.Inline_image)
+	if images == nil {
+		images = make(map[string]string)
+	}
+
+	byPath := make(map[string]string)
+	byHash := make(map[[32]byte]string)
+	for name, data := range images {
+		if b, err := base64.StdEncoding.DecodeString(data); err == nil {
+			byHash[sha256.Sum256(b)] = name
+		}
+	}
+
+	var embedErr error
+	rewritten := imgSrc.ReplaceAllStringFunc(e.HTML, func(tag string) string {
+
+		m := imgSrc.FindStringSubmatch(tag)
+		quoted := m[2]
+		src := strings.Trim(quoted, `"'`)
+		p, ok := localImagePath(html.UnescapeString(src))
+		if !ok || embedErr != nil {
+			return tag
+		}
+
+		name, ok := byPath[p]
+		if !ok {
+			b, err := readImage(fsys, p)
+			if err != nil {
+				embedErr = fmt.Errorf("Could not embed image %s: %+v", src, err)
+				return tag
+			}
+			sum := sha256.Sum256(b)
+			if name, ok = byHash[sum]; !ok {
+				name = uniqueName(images, path.Base(p))
+				images[name] = base64.StdEncoding.EncodeToString(b)
+				byHash[sum] = name
+			}
+			byPath[p] = name
+		}
+
+		q := `"`
+		if strings.HasPrefix(quoted, "'") {
+			q = "'"
+		}
+		return m[1] + q + "{{{" + name + "}}}" + q
+	})
+	if embedErr != nil {
+		return embedErr
+	}
+
+	e.HTML, e.Inline_image = rewritten, images
+
+	return nil
+}
+
+// localImagePath reports the file path referenced by src, if any.
+func localImagePath(src string) (string, bool) {
+
+	src = strings.TrimSpace(src)
+	if src == "" || strings.HasPrefix(src, "{{") || strings.HasPrefix(src, "//") || len(findPercentPlaceholders(src)) > 0 {
+		return "", false
+	}
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "" && u.Scheme != "file") || u.Host != "" {
+		return "", false
+	}
+
+	return u.Path, u.Path != ""
+}
+
+func readImage(fsys fs.FS, p string) ([]byte, error) {
+
+	p = path.Clean(strings.TrimPrefix(p, "/"))
+	if !fs.ValidPath(p) {
+		return nil, fmt.Errorf("Path %s is outside the image directory", p)
+	}
+
+	return fs.ReadFile(fsys, p)
+}
@@ -0,0 +1,68 @@
+package sib
+
+import (
+	"encoding/base64"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbedImages(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"img/logo.png":         {Data: []byte("logo")},
+		"img/copy-of-logo.png": {Data: []byte("logo")},
+		"other/logo.png":       {Data: []byte("other logo")},
+	}
+
+	e := NewEmail()
+	e.HTML = `<img src="img/logo.png"><img alt="x" SRC='./img/logo.png'>` +
+		`<img src=/img/copy-of-logo.png><img src="other/logo.png">` +
+		`<img src="https://cdn.example.com/a.png"><img src="cid:a@b"><img src="{{{kept.png}}}"><img src="%LOGO%">`
+
+	err := e.EmbedImages(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<img src="{{{logo.png}}}"><img alt="x" SRC='{{{logo.png}}}'>` +
+		`<img src="{{{logo.png}}}"><img src="{{{2-logo.png}}}">` +
+		`<img src="https://cdn.example.com/a.png"><img src="cid:a@b"><img src="{{{kept.png}}}"><img src="%LOGO%">`
+	if e.HTML != want {
+		t.Errorf("Image sources are not being rewritten:\n%s\n%s", e.HTML, want)
+	}
+
+	if len(e.Inline_image) != 2 {
+		t.Errorf("Duplicate images are not being deduplicated: %v", e.Inline_image)
+	}
+	if e.Inline_image["2-logo.png"] != base64.StdEncoding.EncodeToString([]byte("other logo")) {
+		t.Errorf("Images are not being embedded: %v", e.Inline_image)
+	}
+
+	e.HTML = `<img src="other/logo.png"><img src="missing.png">`
+	e.Inline_image = map[string]string{}
+	if e.EmbedImages(fsys) == nil {
+		t.Error("Missing images are not being reported.")
+	}
+	if e.HTML != `<img src="other/logo.png"><img src="missing.png">` || len(e.Inline_image) != 0 {
+		t.Errorf("A failed embed is changing the email: %s %v", e.HTML, e.Inline_image)
+	}
+
+	e.HTML = `<img src="../img/logo.png">`
+	if e.EmbedImages(fsys) == nil {
+		t.Error("Paths outside the image directory are not being rejected.")
+	}
+}
+
+func TestEmbedImagesFromDisk(t *testing.T) {
+
+	e := NewEmail()
+	e.HTML = `<img src="test/myimage.jpg">`
+
+	err := e.EmbedImages(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.Inline_image["myimage.jpg"]; !ok || e.HTML != `<img src="{{{myimage.jpg}}}">` {
+		t.Errorf("Images are not being read from disk: %s %v", e.HTML, e.Inline_image)
+	}
+}
@@ -0,0 +1,194 @@
+package sib
+
+import (
+	"sort"
+	"strings"
+)
+
+// InlineCSS moves the rules of the <style> blocks in an HTML document
+// into style attributes, since many email clients drop style sheets.
+// Declarations are applied in cascade order: by !important, then
+// selector specificity, then source order, with existing style
+// attributes taking precedence over non-important rules. Media queries,
+// other at-rules and rules that cannot be inlined, such as :hover, are
+// kept in a single <style> block in <head>. Style blocks marked
+// data-inline="false" are left untouched.
+func InlineCSS(s string) string {
+
+	tokens := tokenizeHTML(s)
+
+	var css []string
+	var styleBlocks [][2]int // token ranges of the style elements to replace
+	headEnd := -1
+	for i := 0; i < len(tokens); i++ {
+		t := &tokens[i]
+		if t.typ == endTagToken && t.name == "head" && headEnd < 0 {
+			headEnd = i
+		}
+		if t.typ != startTagToken || t.name != "style" || t.selfClosing {
+			continue
+		}
+		if v, _ := t.attr("data-inline"); v == "false" {
+			continue
+		}
+		if media, ok := t.attr("media"); ok && media != "" && media != "all" && media != "screen" {
+			continue
+		}
+
+		end := i + 1
+		content := ""
+		if end < len(tokens) && tokens[end].typ == textToken {
+			content = tokens[end].raw
+			end++
+		}
+		if end < len(tokens) && tokens[end].typ == endTagToken && tokens[end].name == "style" {
+			end++
+		}
+		css = append(css, content)
+		styleBlocks = append(styleBlocks, [2]int{i, end})
+		i = end - 1
+	}
+	if len(css) == 0 {
+		return s
+	}
+
+	sheet := parseCSS(strings.Join(css, "\n"))
+	applyCSS(tokens, sheet)
+
+	kept := ""
+	if len(sheet.kept) > 0 {
+		kept = "<style type=\"text/css\">\n" + strings.Join(sheet.kept, "\n") + "\n</style>"
+	}
+
+	var b strings.Builder
+	block := 0
+	for i := 0; i < len(tokens); i++ {
+		if block < len(styleBlocks) && i == styleBlocks[block][0] {
+			// without a head the kept rules replace the first block
+			if block == 0 && headEnd < 0 {
+				b.WriteString(kept)
+			}
+			i = styleBlocks[block][1] - 1
+			block++
+			continue
+		}
+		if i == headEnd {
+			b.WriteString(kept)
+		}
+		b.WriteString(tokens[i].raw)
+	}
+
+	return b.String()
+}
+
+// applyCSS builds the element tree and rewrites the style attribute of
+// every body element matched by a rule.
+func applyCSS(tokens []htmlToken, sheet cssSheet) {
+
+	type match struct {
+		decl        cssDecl
+		specificity int
+		order       int
+	}
+
+	type compiled struct {
+		sel   cssSelector
+		rule  int
+		order int
+	}
+	var selectors []compiled
+	for i, r := range sheet.rules {
+		for _, s := range r.selectors {
+			sel, _ := parseSelector(s)
+			selectors = append(selectors, compiled{sel, i, len(selectors)})
+		}
+	}
+
+	root := &cssElement{}
+	stack := []*cssElement{root}
+	inHead := false
+
+	for i := range tokens {
+		t := &tokens[i]
+		switch t.typ {
+		case startTagToken:
+			parent := stack[len(stack)-1]
+			e := &cssElement{tok: t, parent: parent, index: len(parent.children)}
+			parent.children = append(parent.children, e)
+			if t.name == "head" {
+				inHead = true
+			}
+			if !voidElements[t.name] && !t.selfClosing {
+				stack = append(stack, e)
+			}
+
+			if inHead || droppedElements[t.name] {
+				continue
+			}
+
+			var matches []match
+			for _, c := range selectors {
+				if c.sel.matches(e) {
+					for _, d := range sheet.rules[c.rule].decls {
+						matches = append(matches, match{d, c.sel.specificity(), c.order})
+					}
+				}
+			}
+			if len(matches) == 0 {
+				continue
+			}
+			sort.SliceStable(matches, func(a, b int) bool {
+				ma, mb := matches[a], matches[b]
+				if ma.decl.important != mb.decl.important {
+					return !ma.decl.important
+				}
+				if ma.specificity != mb.specificity {
+					return ma.specificity < mb.specificity
+				}
+				return ma.order < mb.order
+			})
+
+			var decls []cssDecl
+			set := func(d cssDecl) {
+				for i := range decls {
+					if decls[i].prop == d.prop {
+						decls[i] = d
+						return
+					}
+				}
+				decls = append(decls, d)
+			}
+			for _, m := range matches {
+				set(m.decl)
+			}
+			style, _ := t.attr("style")
+			for _, d := range parseDecls(style) {
+				if prev := findDecl(decls, d.prop); prev != nil && prev.important && !d.important {
+					continue
+				}
+				set(d)
+			}
+			t.setAttr("style", formatDecls(decls))
+
+		case endTagToken:
+			if t.name == "head" {
+				inHead = false
+			}
+			for j := len(stack) - 1; j > 0; j-- {
+				if stack[j].tok.name == t.name {
+					stack = stack[:j]
+					break
+				}
+			}
+		}
+	}
+}
+
+func findDecl(decls []cssDecl, prop string) *cssDecl {
+	for i := range decls {
+		if decls[i].prop == prop {
+			return &decls[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package sib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInlineCSS(t *testing.T) {
+
+	in := `<html><head><title>T</title>
+<style>
+/* base */
+p { color: black; margin: 0 }
+.note { color: gray }
+#main p.note { color: red }
+td > a[href^="https"] { text-decoration: none }
+a, a:hover { color: blue }
+h1 + p { font-weight: bold !important }
+@media (max-width: 600px) { p { font-size: 18px } }
+</style>
+<style data-inline="false">.keep { color: green }</style>
+</head><body>
+<div id="main"><h1>Title</h1><p class="note" style="margin: 4px; font-weight: normal">Hi</p></div>
+<p>Plain</p>
+<table><tr><td><a href="https://example.com">x</a></td></tr></table>
+<a href="https://example.com/?a=1&amp;b=2">y</a>
+</body></html>`
+
+	out := InlineCSS(in)
+
+	for _, want := range []string{
+		`<p class="note" style="color: red; margin: 4px; font-weight: bold !important">Hi</p>`,
+		`<p style="color: black; margin: 0">Plain</p>`,
+		`<a href="https://example.com" style="color: blue; text-decoration: none">x</a>`,
+		`<a href="https://example.com/?a=1&amp;b=2" style="color: blue">y</a>`,
+		`<style data-inline="false">.keep { color: green }</style>`,
+		"@media (max-width: 600px) { p { font-size: 18px } }",
+		"a:hover { color: blue }",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in:\n%s", want, out)
+		}
+	}
+
+	head := out[:strings.Index(out, "</head>")]
+	if !strings.Contains(head, "@media") {
+		t.Error("Media queries are not being kept in the head.")
+	}
+	if strings.Contains(out, ".note { color: gray }") || strings.Contains(out, "/* base */") {
+		t.Errorf("Inlined rules are being kept:\n%s", out)
+	}
+	if strings.Contains(out, "<title") && !strings.Contains(out, "<title>T</title>") {
+		t.Error("Head content is being changed.")
+	}
+
+	if InlineCSS("<p>no styles</p>") != "<p>no styles</p>" {
+		t.Error("Documents without style sheets are being changed.")
+	}
+}
+
+func TestInlineCSSDescendantBacktracking(t *testing.T) {
+
+	selector := "table" + strings.Repeat(" div", 14) + " span"
+	in := "<style>" + selector + " { color: red }</style>" +
+		strings.Repeat("<div>", 30) + "<span>x</span>" + strings.Repeat("</div>", 30)
+
+	start := time.Now()
+	out := InlineCSS(in)
+	if time.Since(start) > time.Second {
+		t.Errorf("Descendant selectors are backtracking exponentially: %v", time.Since(start))
+	}
+	if strings.Contains(out, "color: red") {
+		t.Error("A selector without a matching table is being applied.")
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+
+	for sel, spec := range map[string]int{
+		"p":               1,
+		"div > p.note":    1<<8 | 2,
+		"#a .b [c] d":     1<<16 | 2<<8 | 1,
+		"*":               0,
+		"ul li + li ~ li": 4,
+		`a[href$=".pdf"]`: 1<<8 | 1,
+	} {
+		s, ok := parseSelector(sel)
+		if !ok {
+			t.Errorf("Selector %q is not being parsed.", sel)
+			continue
+		}
+		if s.specificity() != spec {
+			t.Errorf("Specificity of %q is %x, want %x.", sel, s.specificity(), spec)
+		}
+	}
+
+	for _, sel := range []string{"a:hover", "p::before", "> p", "p >", "a[", ""} {
+		if _, ok := parseSelector(sel); ok {
+			t.Errorf("Selector %q is being accepted.", sel)
+		}
+	}
+}
+
+func TestClientInlineCSS(t *testing.T) {
+
+	var body string
+	client, _ := NewClient("123", WithInlineCSS())
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	tmpl := &Template{Html_content: "<style>p{color:red}</style><p>x</p>"}
+	client.CreateTemplate(tmpl)
+	if !strings.Contains(body, `style=\"color: red\"`) {
+		t.Errorf("Template HTML is not being inlined: %s", body)
+	}
+	if tmpl.Html_content != "<style>p{color:red}</style><p>x</p>" {
+		t.Error("The caller's template is being modified.")
+	}
+
+	e := NewEmail()
+	e.HTML = tmpl.Html_content
+	client.SendEmail(e)
+	if !strings.Contains(body, `style=\"color: red\"`) {
+		t.Errorf("Email HTML is not being inlined: %s", body)
+	}
+}
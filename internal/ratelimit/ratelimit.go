@@ -0,0 +1,92 @@
+// Package ratelimit provides the token bucket shared by the tenant
+// registry and the SMTP bridge.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket allowing rate events per second with bursts
+// of up to burst events. It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// New returns a full Limiter. A burst below 1 is treated as 1.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow takes a token, reporting false when none is left.
+func (l *Limiter) Allow(now time.Time) bool {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
+
+// Wait blocks until a token can be taken or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Full reports whether the bucket has refilled completely.
+func (l *Limiter) Full(now time.Time) bool {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+
+	return l.tokens >= l.burst
+}
+
+// refill adds the tokens earned since the last call. A time before it,
+// from a caller that read the clock before taking the lock, adds none.
+func (l *Limiter) refill(now time.Time) {
+	if now.Before(l.last) {
+		return
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
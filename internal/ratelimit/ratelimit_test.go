@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+
+	l := New(1, 2)
+	now := time.Now()
+	if !l.Allow(now) || !l.Allow(now) {
+		t.Fatal("Bursts are not being allowed.")
+	}
+	if l.Allow(now) {
+		t.Error("Sends over the burst are being allowed.")
+	}
+	if l.Full(now.Add(time.Second)) {
+		t.Error("The bucket is refilling faster than the rate.")
+	}
+	if !l.Full(now.Add(2 * time.Second)) {
+		t.Error("The bucket is not refilling.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	l = New(0.1, 1)
+	l.Allow(time.Now())
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait is not honoring the context: %v", err)
+	}
+}
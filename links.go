@@ -0,0 +1,155 @@
+package sib
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TagHeader is the header carrying an email's tag.
+const TagHeader = "X-Mailin-Tag"
+
+// LinkParams are query parameters added to links, such as
+// {"utm_source": "sendinblue", "utm_medium": "email", "utm_campaign": "{tag}"}.
+// In the client hook "{tag}" is replaced by the message tag.
+type LinkParams map[string]string
+
+var bracePlaceholderSegment = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// placeholderSegments returns the locations of the {{ }} and %NAME%
+// placeholders in s, in order.
+func placeholderSegments(s string) [][]int {
+
+	locs := bracePlaceholderSegment.FindAllStringIndex(s, -1)
+	for _, m := range findPercentPlaceholders(s) {
+		locs = append(locs, m[:2])
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i][0] < locs[j][0] })
+
+	return locs
+}
+
+// RewriteLinks adds params to the href of every <a> and <area> in the
+// HTML. Parameters a link already has are kept. Links are left alone
+// when they are mailto:, tel:, sms:, javascript: or in-page anchors,
+// when they look like unsubscribe links, or when a placeholder appears
+// before the query, since its value may bring a query of its own.
+// Placeholders in the query and fragment, and in parameter values, are
+// kept as written.
+func RewriteLinks(s string, params LinkParams) string {
+
+	if len(params) == 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for _, t := range tokenizeHTML(s) {
+		if t.typ == startTagToken && (t.name == "a" || t.name == "area") {
+			if href, ok := t.attr("href"); ok {
+				if rewritten := addLinkParams(href, params); rewritten != href {
+					t.setAttr("href", rewritten)
+				}
+			}
+		}
+		b.WriteString(t.raw)
+	}
+
+	return b.String()
+}
+
+func addLinkParams(href string, params LinkParams) string {
+
+	trimmed := strings.TrimSpace(href)
+	lower := strings.ToLower(trimmed)
+	for _, prefix := range []string{"mailto:", "tel:", "sms:", "javascript:", "#"} {
+		if strings.HasPrefix(lower, prefix) {
+			return href
+		}
+	}
+	if trimmed == "" || strings.Contains(lower, "unsubscribe") || strings.Contains(lower, "optout") || strings.Contains(lower, "opt-out") {
+		return href
+	}
+	if locs := placeholderSegments(trimmed); len(locs) > 0 && !strings.ContainsAny(trimmed[:locs[0][0]], "?#") {
+		// part of the address comes from a placeholder
+		return href
+	}
+
+	base, fragment := trimmed, ""
+	if i := strings.IndexByte(base, '#'); i >= 0 {
+		base, fragment = base[:i], base[i:]
+	}
+
+	existing := make(map[string]bool)
+	if i := strings.IndexByte(base, '?'); i >= 0 {
+		for _, kv := range strings.Split(base[i+1:], "&") {
+			k := strings.SplitN(kv, "=", 2)[0]
+			if k, err := url.QueryUnescape(k); err == nil {
+				existing[k] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if !existing[k] {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return href
+	}
+	sort.Strings(keys)
+
+	var add []string
+	for _, k := range keys {
+		add = append(add, url.QueryEscape(k)+"="+escapeKeepingPlaceholders(params[k]))
+	}
+
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+		if strings.HasSuffix(base, "?") || strings.HasSuffix(base, "&") {
+			sep = ""
+		}
+	}
+
+	return base + sep + strings.Join(add, "&") + fragment
+}
+
+func escapeKeepingPlaceholders(v string) string {
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range placeholderSegments(v) {
+		if loc[0] < last {
+			continue // a %NAME% inside {{ }}
+		}
+		b.WriteString(url.QueryEscape(v[last:loc[0]]))
+		b.WriteString(v[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(url.QueryEscape(v[last:]))
+
+	return b.String()
+}
+
+// WithLinkParams adds params to the links of emails sent with SendEmail
+// and templates saved with CreateTemplate and UpdateTemplate; see
+// RewriteLinks. "{tag}" in a value is replaced by the email's
+// X-Mailin-Tag header or, for templates, by the template name, since
+// template sends cannot be rewritten.
+func WithLinkParams(params LinkParams) Option {
+	return func(c *Client) {
+		c.linkParams = params
+	}
+}
+
+// tagged replaces "{tag}" in the values of p.
+func (p LinkParams) tagged(tag string) LinkParams {
+	out := make(LinkParams, len(p))
+	for k, v := range p {
+		out[k] = strings.Replace(v, "{tag}", tag, -1)
+	}
+	return out
+}
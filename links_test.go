@@ -0,0 +1,64 @@
+package sib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRewriteLinks(t *testing.T) {
+
+	params := LinkParams{"utm_source": "sib", "utm_campaign": "spring sale"}
+
+	for in, want := range map[string]string{
+		`<a href="https://example.com/">x</a>`:                                `<a href="https://example.com/?utm_campaign=spring+sale&amp;utm_source=sib">x</a>`,
+		`<a href="https://example.com/p?id=1#top">x</a>`:                      `<a href="https://example.com/p?id=1&amp;utm_campaign=spring+sale&amp;utm_source=sib#top">x</a>`,
+		`<a href="https://example.com/?utm_source=news">x</a>`:                `<a href="https://example.com/?utm_source=news&amp;utm_campaign=spring+sale">x</a>`,
+		`<a href="https://example.com/u?id={{ contact.ID }}&e=%EMAIL%">x</a>`: `<a href="https://example.com/u?id={{ contact.ID }}&amp;e=%EMAIL%&amp;utm_campaign=spring+sale&amp;utm_source=sib">x</a>`,
+		`<a href="https://example.com/caf%C3%A9">x</a>`:                       `<a href="https://example.com/caf%C3%A9?utm_campaign=spring+sale&amp;utm_source=sib">x</a>`,
+		`<a href="https://example.com/{{ params.PAGE }}">x</a>`:               `<a href="https://example.com/{{ params.PAGE }}">x</a>`,
+		`<a href="mailto:a@example.com">x</a>`:                                `<a href="mailto:a@example.com">x</a>`,
+		`<a href='tel:+331'>x</a>`:                                            `<a href='tel:+331'>x</a>`,
+		`<a href="#top">x</a>`:                                                `<a href="#top">x</a>`,
+		`<a href="https://example.com/unsubscribe?id=1">x</a>`:                `<a href="https://example.com/unsubscribe?id=1">x</a>`,
+		`<a href="{{ params.LINK }}">x</a>`:                                   `<a href="{{ params.LINK }}">x</a>`,
+		`<a name="anchor">x</a><p>https://example.com</p>`:                    `<a name="anchor">x</a><p>https://example.com</p>`,
+	} {
+		if got := RewriteLinks(in, params); got != want {
+			t.Errorf("RewriteLinks(%s):\n got %s\nwant %s", in, got, want)
+		}
+	}
+
+	got := RewriteLinks(`<a href="https://example.com">x</a>`, LinkParams{"utm_content": "{{ params.VARIANT }}"})
+	if got != `<a href="https://example.com?utm_content={{ params.VARIANT }}">x</a>` {
+		t.Errorf("Placeholders in parameter values are being escaped: %s", got)
+	}
+}
+
+func TestClientLinkParams(t *testing.T) {
+
+	var body string
+	client, _ := NewClient("123", WithLinkParams(LinkParams{"utm_campaign": "{tag}"}))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	e := NewEmail()
+	e.HTML = `<a href="https://example.com">x</a>`
+	e.Headers[TagHeader] = "welcome"
+	client.SendEmail(e)
+	if !strings.Contains(body, `https://example.com?utm_campaign=welcome`) {
+		t.Errorf("Email links are not being tagged: %s", body)
+	}
+	if strings.Contains(e.HTML, "utm_") {
+		t.Error("The caller's email is being modified.")
+	}
+
+	client.UpdateTemplate(1, &Template{Template_name: "onboarding", Html_content: e.HTML})
+	if !strings.Contains(body, `https://example.com?utm_campaign=onboarding`) {
+		t.Errorf("Template links are not being tagged with the template name: %s", body)
+	}
+}
@@ -0,0 +1,291 @@
+package sib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severity ranks lint findings.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// MarshalText makes severities readable in JSON output.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParseSeverity parses "info", "warning" or "error".
+func ParseSeverity(s string) (Severity, error) {
+	for _, sev := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		if strings.EqualFold(s, sev.String()) {
+			return sev, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown severity %q", s)
+}
+
+// Lint rule names.
+const (
+	LintImageAlt           = "image-alt"
+	LintUnbalancedTag      = "unbalanced-tag"
+	LintRelativeLink       = "relative-link"
+	LintInsecureLink       = "insecure-link"
+	LintSize               = "size"
+	LintUnsubscribe        = "unsubscribe"
+	LintUnknownPlaceholder = "unknown-placeholder"
+)
+
+// GmailClipSize is the HTML size above which Gmail clips messages.
+const GmailClipSize = 102 * 1024
+
+// A Finding is one problem found by LintHTML.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Line     int      `json:"line"` // 1-based, 0 for the whole document
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%d: %s: %s (%s)", f.Line, f.Severity, f.Message, f.Rule)
+}
+
+// LintOptions configure LintHTML.
+type LintOptions struct {
+	// Marketing requires an unsubscribe link.
+	Marketing bool
+
+	// Attributes lists the known placeholder names. When nil, placeholders
+	// are not checked.
+	Attributes []string
+
+	// MaxSize overrides GmailClipSize.
+	MaxSize int
+}
+
+// LintError is returned by the client hook when HTML has findings of
+// error severity.
+type LintError struct {
+	Findings []Finding
+}
+
+func (e *LintError) Error() string {
+	msgs := make([]string, len(e.Findings))
+	for i, f := range e.Findings {
+		msgs[i] = f.String()
+	}
+	return "HTML lint failed: " + strings.Join(msgs, "; ")
+}
+
+// optionalEndTags may be left unclosed.
+var optionalEndTags = map[string]bool{
+	"body": true, "colgroup": true, "dd": true, "dt": true, "head": true,
+	"html": true, "li": true, "option": true, "p": true, "tbody": true,
+	"td": true, "tfoot": true, "th": true, "thead": true, "tr": true,
+}
+
+var unsubscribePlaceholder = regexp.MustCompile(`(?i)%UNSUBSCRIBE%|\[UNSUBSCRIBE\]|\{\{\s*unsubscribe\s*\}\}`)
+
+// LintHTML checks email HTML for common problems: images without alt
+// text, unbalanced tags, relative and http:// links, content large
+// enough to be clipped by Gmail, marketing mail without an unsubscribe
+// link and unknown placeholders. Findings are sorted by line.
+func LintHTML(s string, opts LintOptions) []Finding {
+
+	var findings []Finding
+	lineAt := lineCounter(s)
+	add := func(sev Severity, rule string, offset int, format string, args ...interface{}) {
+		line := 0
+		if offset >= 0 {
+			line = lineAt(offset)
+		}
+		findings = append(findings, Finding{sev, rule, line, fmt.Sprintf(format, args...)})
+	}
+
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = GmailClipSize
+	}
+	if len(s) > maxSize {
+		add(SeverityWarning, LintSize, -1, "HTML is %d bytes; Gmail clips messages over %d bytes", len(s), maxSize)
+	}
+
+	type open struct {
+		name   string
+		offset int
+	}
+	var stack []open
+	unsubscribe := unsubscribePlaceholder.MatchString(s)
+
+	for _, t := range tokenizeHTML(s) {
+		switch t.typ {
+		case startTagToken:
+			if t.name == "img" {
+				if _, ok := t.attr("alt"); !ok {
+					src, _ := t.attr("src")
+					add(SeverityWarning, LintImageAlt, t.offset, "Image %s has no alt text", src)
+				}
+			}
+			for _, key := range []string{"href", "src", "background"} {
+				v, ok := t.attr(key)
+				if !ok {
+					continue
+				}
+				switch linkKind(v) {
+				case "relative":
+					add(SeverityError, LintRelativeLink, t.offset, "Relative %s %q on <%s> will not resolve in an email", key, v, t.name)
+				case "insecure":
+					add(SeverityWarning, LintInsecureLink, t.offset, "Insecure %s %q on <%s>; use https://", key, v, t.name)
+				}
+				if key == "href" && strings.Contains(strings.ToLower(v), "unsubscribe") {
+					unsubscribe = true
+				}
+			}
+			if !voidElements[t.name] && !t.selfClosing {
+				stack = append(stack, open{t.name, t.offset})
+			}
+
+		case endTagToken:
+			if voidElements[t.name] {
+				continue
+			}
+			i := len(stack) - 1
+			for i >= 0 && stack[i].name != t.name {
+				i--
+			}
+			if i < 0 {
+				add(SeverityError, LintUnbalancedTag, t.offset, "Closing </%s> has no matching opening tag", t.name)
+				continue
+			}
+			for _, o := range stack[i+1:] {
+				if !optionalEndTags[o.name] {
+					add(SeverityError, LintUnbalancedTag, o.offset, "<%s> is not closed before </%s>", o.name, t.name)
+				}
+			}
+			stack = stack[:i]
+		}
+	}
+	for _, o := range stack {
+		if !optionalEndTags[o.name] {
+			add(SeverityError, LintUnbalancedTag, o.offset, "<%s> is never closed", o.name)
+		}
+	}
+
+	if opts.Marketing && !unsubscribe {
+		add(SeverityError, LintUnsubscribe, -1, "Marketing email has no unsubscribe link")
+	}
+
+	if opts.Attributes != nil {
+		known := make(map[string]bool)
+		for _, a := range opts.Attributes {
+			known[strings.ToUpper(a)] = true
+		}
+		offsets := placeholderOffsets(s)
+		for _, name := range Placeholders(s) {
+			if !known[name] {
+				add(SeverityWarning, LintUnknownPlaceholder, offsets[name], "Unknown placeholder %s", name)
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Line < findings[j].Line
+	})
+
+	return findings
+}
+
+// linkKind classifies a URL as "relative", "insecure" or "".
+func linkKind(v string) string {
+
+	v = strings.TrimSpace(v)
+	lower := strings.ToLower(v)
+
+	switch {
+	case v == "", strings.HasPrefix(v, "#"), strings.HasPrefix(v, "{{"), strings.HasPrefix(v, "["):
+		return ""
+	case percentPlaceholder.MatchString(v) && !isPercentEncoding(percentPlaceholder.FindStringSubmatch(v)[1]):
+		return ""
+	case strings.HasPrefix(lower, "http://"):
+		return "insecure"
+	case strings.HasPrefix(lower, "https://"), strings.HasPrefix(v, "//"):
+		return ""
+	}
+
+	if i := strings.IndexByte(lower, ':'); i > 0 && !strings.ContainsAny(lower[:i], "/?#") {
+		// mailto:, tel:, cid:, data: and other schemes
+		return ""
+	}
+
+	return "relative"
+}
+
+// lineCounter returns a function mapping byte offsets in s to 1-based
+// line numbers.
+func lineCounter(s string) func(offset int) int {
+	var starts []int
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return func(offset int) int {
+		return sort.SearchInts(starts, offset+1) + 1
+	}
+}
+
+// WithLint lints HTML before SendEmail, CreateTemplate and UpdateTemplate
+// and refuses to send when there are findings of error severity,
+// returning a *LintError. Warnings are passed to warn, if set. Linting
+// runs last, on the HTML as it will be sent, after every HTMLFilter and
+// link rewriting.
+func WithLint(opts LintOptions, warn func([]Finding)) Option {
+	return func(c *Client) {
+		c.lint = &lintConfig{opts: opts, warn: warn}
+	}
+}
+
+type lintConfig struct {
+	opts LintOptions
+	warn func([]Finding)
+}
+
+// check lints s, returning a *LintError for findings of error severity.
+func (l *lintConfig) check(s string) error {
+
+	var errs, warnings []Finding
+	for _, f := range LintHTML(s, l.opts) {
+		if f.Severity >= SeverityError {
+			errs = append(errs, f)
+		} else {
+			warnings = append(warnings, f)
+		}
+	}
+	if len(errs) > 0 {
+		return &LintError{Findings: errs}
+	}
+	if len(warnings) > 0 && l.warn != nil {
+		l.warn(warnings)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package sib
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func rules(findings []Finding) string {
+	var r []string
+	for _, f := range findings {
+		r = append(r, f.Rule)
+	}
+	return strings.Join(r, ",")
+}
+
+func TestLintHTML(t *testing.T) {
+
+	clean := `<html><body>
+<img src="https://example.com/a.png" alt="">
+<img src="{{{logo.png}}}" alt="Logo"><br>
+<p>Hi {{ params.NAME }}<p>Open
+<a href="mailto:a@example.com">mail</a> <a href="#x">x</a> <a href="[UNSUBSCRIBE]">unsubscribe</a>
+</body></html>`
+	if f := LintHTML(clean, LintOptions{Marketing: true, Attributes: []string{"name"}}); len(f) != 0 {
+		t.Errorf("Clean HTML has findings: %v", f)
+	}
+
+	dirty := `<div>
+<img src="images/a.png">
+<a href="http://example.com">x</a>
+<span>%COUPON%</div>
+</table>`
+	f := LintHTML(dirty, LintOptions{Marketing: true, Attributes: []string{}})
+	if got := rules(f); got != "unsubscribe,image-alt,relative-link,insecure-link,unbalanced-tag,unknown-placeholder,unbalanced-tag" {
+		t.Errorf("Unexpected findings: %v", f)
+	}
+	if f[1].Line != 2 || f[4].Line != 4 || f[5].Line != 4 || f[6].Line != 5 {
+		t.Errorf("Line numbers are not being reported: %v", f)
+	}
+	if f[0].Severity != SeverityError || f[1].Severity != SeverityWarning {
+		t.Errorf("Severities are not being set: %v", f)
+	}
+
+	big := "<p>" + strings.Repeat("x", GmailClipSize) + "</p>"
+	if got := rules(LintHTML(big, LintOptions{})); got != LintSize {
+		t.Errorf("Oversized HTML is not being reported: %s", got)
+	}
+}
+
+func TestClientLint(t *testing.T) {
+
+	sent := false
+	var warned []Finding
+	client, _ := NewClient("123", WithLint(LintOptions{}, func(f []Finding) { warned = f }))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent = true
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	err := client.UpdateTemplate(1, &Template{Html_content: `<div><a href="/x">x</a>`})
+	var lintErr *LintError
+	if !errors.As(err, &lintErr) || len(lintErr.Findings) != 2 || sent {
+		t.Errorf("HTML with errors is not being refused: %v", err)
+	}
+
+	e := NewEmail()
+	e.HTML = `<img src="https://example.com/a.png">`
+	_, err = client.SendEmail(e)
+	if err != nil || !sent || rules(warned) != LintImageAlt {
+		t.Errorf("Warnings are blocking the send or not being reported: %v %v", err, warned)
+	}
+}
+
+func TestClientLintRunsLast(t *testing.T) {
+
+	client, _ := NewClient("123",
+		WithLint(LintOptions{}, nil),
+		WithHTMLFilter(func(s string) (string, error) { return s + `<a href="/x">x</a>`, nil }),
+	)
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	e := NewEmail()
+	e.HTML = `<p>Hi</p>`
+	_, err := client.SendEmail(e)
+	var lintErr *LintError
+	if !errors.As(err, &lintErr) || rules(lintErr.Findings) != LintRelativeLink {
+		t.Errorf("Linting is not running after HTML filters: %v", err)
+	}
+}
+
+func TestLintIgnoresURLEncoding(t *testing.T) {
+
+	f := LintHTML(`<a href="https://example.com/caf%C3%A9%E2%80%99">x</a>`, LintOptions{Attributes: []string{}})
+	if len(f) != 0 {
+		t.Errorf("URL percent-encoding is being reported: %v", f)
+	}
+}
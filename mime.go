@@ -0,0 +1,418 @@
+package sib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteMIME writes e as an RFC 5322 message with a multipart MIME body:
+// text and HTML as alternatives, inline images as related parts
+// referenced by Content-ID, and attachments. Attachments given as URLs
+// are downloaded.
+func (e *Email) WriteMIME(w io.Writer) error {
+	return e.writeMIME(w, "")
+}
+
+// writeMIME writes e with the given Message-Id, or a generated one.
+func (e *Email) writeMIME(w io.Writer, messageID string) error {
+
+	m, err := newMIMEMessage(e, httpFetch)
+	if err != nil {
+		return err
+	}
+	if messageID != "" {
+		if !strings.HasPrefix(messageID, "<") {
+			messageID = "<" + messageID + ">"
+		}
+		m.messageID = messageID
+		m.header.Set("Message-Id", messageID)
+	}
+
+	_, err = m.WriteTo(w)
+	if err != nil {
+		err = fmt.Errorf("Could not write MIME message: %+v", err)
+		return err
+	}
+
+	return nil
+}
+
+// mimeMessage is an Email prepared for MIME rendering: attachments and
+// inline images are decoded and the recipients are known.
+type mimeMessage struct {
+	header      textproto.MIMEHeader
+	text, html  string
+	inline      []mimePart
+	attachments []mimePart
+	messageID   string
+}
+
+type mimePart struct {
+	name, contentID string
+	data            []byte
+}
+
+// reservedHeaders are written from the Email's own fields and cannot be
+// set through Email.Headers.
+var reservedHeaders = map[string]bool{
+	"Bcc":                       true,
+	"Cc":                        true,
+	"Content-Disposition":       true,
+	"Content-Transfer-Encoding": true,
+	"Content-Type":              true,
+	"Date":                      true,
+	"From":                      true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"To":                        true,
+}
+
+// checkHeader rejects custom headers that would corrupt the message.
+func checkHeader(name, value string) error {
+
+	if name == "" {
+		return fmt.Errorf("Could not use header: empty name")
+	}
+	for _, r := range name {
+		if r < '!' || r > '~' || r == ':' {
+			return fmt.Errorf("Could not use header %q: invalid name", name)
+		}
+	}
+	if reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+		return fmt.Errorf("Could not use header %q: it is set from the email", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("Could not use header %q: value contains a line break", name)
+	}
+
+	return nil
+}
+
+var unsafeCID = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// newMIMEMessage prepares e for rendering. URL attachments are fetched
+// with fetch; other attachment and inline image values must be base64.
+func newMIMEMessage(e *Email, fetch func(url string) ([]byte, error)) (*mimeMessage, error) {
+
+	m := &mimeMessage{
+		header: make(textproto.MIMEHeader),
+		text:   e.Text,
+		html:   e.HTML,
+	}
+
+	domain := "sendinblue.invalid"
+	if i := strings.LastIndex(e.From[0], "@"); i >= 0 {
+		domain = e.From[0][i+1:]
+	}
+	m.messageID = newMessageID(domain)
+
+	for k, v := range e.Headers {
+		if err := checkHeader(k, v); err != nil {
+			return nil, err
+		}
+		m.header.Set(k, mime.QEncoding.Encode("utf-8", v))
+	}
+	m.header.Set("Message-Id", m.messageID)
+	m.header.Set("Date", time.Now().Format(time.RFC1123Z))
+	m.header.Set("Mime-Version", "1.0")
+	m.header.Set("From", formatAddress(e.From[0], e.From[1]))
+	m.header.Set("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	if e.ReplyTo[0] != "" {
+		m.header.Set("Reply-To", formatAddress(e.ReplyTo[0], e.ReplyTo[1]))
+	}
+	if len(e.To) > 0 {
+		m.header.Set("To", formatAddressMap(e.To))
+	}
+	if len(e.CC) > 0 {
+		m.header.Set("Cc", formatAddressMap(e.CC))
+	}
+
+	for _, name := range sortedKeys(e.Inline_image) {
+		data, err := base64.StdEncoding.DecodeString(e.Inline_image[name])
+		if err != nil {
+			err = fmt.Errorf("Could not decode inline image %s: %+v", name, err)
+			return nil, err
+		}
+		cid := unsafeCID.ReplaceAllString(filepath.Base(name), "_") + "@" + strings.Trim(m.messageID, "<>")
+		m.inline = append(m.inline, mimePart{name: filepath.Base(name), contentID: cid, data: data})
+		m.html = strings.Replace(m.html, "{{{"+name+"}}}", "cid:"+cid, -1)
+	}
+
+	for _, name := range sortedKeys(e.Attachment) {
+		value := e.Attachment[name]
+		var data []byte
+		var err error
+		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			data, err = fetch(value)
+		} else {
+			data, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil {
+			err = fmt.Errorf("Could not load attachment %s: %+v", name, err)
+			return nil, err
+		}
+		m.attachments = append(m.attachments, mimePart{name: filepath.Base(name), data: data})
+	}
+
+	return m, nil
+}
+
+// WriteTo renders the message as RFC 5322 with a MIME body:
+// multipart/mixed for attachments, multipart/related for inline images
+// and multipart/alternative for text and HTML, each only when needed.
+func (m *mimeMessage) WriteTo(w io.Writer) (int64, error) {
+
+	var buf bytes.Buffer
+
+	keys := make([]string, 0, len(m.header))
+	for k := range m.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range m.header[k] {
+			writeHeader(&buf, k, v)
+		}
+	}
+
+	err := m.writeMixed(&buf)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// writeHeader writes a header field folded at spaces, so that lines stay
+// within 78 characters where the value allows it (RFC 5322 2.1.1).
+func writeHeader(buf *bytes.Buffer, name, value string) {
+
+	buf.WriteString(name + ":")
+	n := len(name) + 1
+	for i, word := range strings.Split(value, " ") {
+		if i > 0 && word != "" && n+1+len(word) > 78 {
+			buf.WriteString("\r\n")
+			n = 0
+		}
+		buf.WriteString(" " + word)
+		n += 1 + len(word)
+	}
+	buf.WriteString("\r\n")
+}
+
+func (m *mimeMessage) writeMixed(buf *bytes.Buffer) error {
+
+	if len(m.attachments) == 0 {
+		return m.writeRelated(buf, nil)
+	}
+
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	if err := m.writeRelated(nil, mw); err != nil {
+		return err
+	}
+	for _, a := range m.attachments {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", contentType(a.name, a.data)+"; name="+quoteParam(a.name))
+		h.Set("Content-Disposition", "attachment; filename="+quoteParam(a.name))
+		if err := writeBase64Part(mw, h, a.data); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// writeRelated writes the body either as the top-level body into buf or
+// as a part of parent.
+func (m *mimeMessage) writeRelated(buf *bytes.Buffer, parent *multipart.Writer) error {
+
+	if len(m.inline) == 0 || m.html == "" {
+		return m.writeAlternative(buf, parent)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	ct := "multipart/related; boundary=" + mw.Boundary() + `; type="text/html"`
+	if m.text != "" {
+		ct = "multipart/related; boundary=" + mw.Boundary() + `; type="multipart/alternative"`
+	}
+
+	if err := m.writeAlternative(nil, mw); err != nil {
+		return err
+	}
+	for _, img := range m.inline {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", contentType(img.name, img.data)+"; name="+quoteParam(img.name))
+		h.Set("Content-Disposition", "inline; filename="+quoteParam(img.name))
+		h.Set("Content-Id", "<"+img.contentID+">")
+		if err := writeBase64Part(mw, h, img.data); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	return emit(buf, parent, ct, "", body.Bytes())
+}
+
+func (m *mimeMessage) writeAlternative(buf *bytes.Buffer, parent *multipart.Writer) error {
+
+	switch {
+	case m.html == "":
+		return emitText(buf, parent, "text/plain", m.text)
+	case m.text == "":
+		return emitText(buf, parent, "text/html", m.html)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := emitText(nil, mw, "text/plain", m.text); err != nil {
+		return err
+	}
+	if err := emitText(nil, mw, "text/html", m.html); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	return emit(buf, parent, "multipart/alternative; boundary="+mw.Boundary(), "", body.Bytes())
+}
+
+func emitText(buf *bytes.Buffer, parent *multipart.Writer, mediaType, s string) error {
+
+	var body bytes.Buffer
+	qp := quotedprintable.NewWriter(&body)
+	qp.Write([]byte(s))
+	qp.Close()
+
+	return emit(buf, parent, mediaType+"; charset=utf-8", "quoted-printable", body.Bytes())
+}
+
+// emit writes an entity either as the message body (headers into buf)
+// or as a new part of parent.
+func emit(buf *bytes.Buffer, parent *multipart.Writer, contentType, encoding string, body []byte) error {
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType)
+	if encoding != "" {
+		h.Set("Content-Transfer-Encoding", encoding)
+	}
+
+	if parent != nil {
+		w, err := parent.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(body)
+		return err
+	}
+
+	for _, k := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if v := h.Get(k); v != "" {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	return nil
+}
+
+func writeBase64Part(mw *multipart.Writer, h textproto.MIMEHeader, data []byte) error {
+
+	h.Set("Content-Transfer-Encoding", "base64")
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+
+	s := base64.StdEncoding.EncodeToString(data)
+	for len(s) > 76 {
+		io.WriteString(w, s[:76]+"\r\n")
+		s = s[76:]
+	}
+	_, err = io.WriteString(w, s+"\r\n")
+
+	return err
+}
+
+func contentType(name string, data []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return strings.Split(t, ";")[0]
+	}
+	return strings.Split(http.DetectContentType(data), ";")[0]
+}
+
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(mime.QEncoding.Encode("utf-8", s)) + `"`
+}
+
+func formatAddress(addr, name string) string {
+	return (&mail.Address{Name: name, Address: addr}).String()
+}
+
+func formatAddressMap(m map[string]string) string {
+	var list []string
+	for _, addr := range sortedKeys(m) {
+		list = append(list, formatAddress(addr, m[addr]))
+	}
+	return strings.Join(list, ", ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newMessageID(domain string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
+
+// fetchClient loads URL attachments. A bounded timeout keeps a slow host
+// from holding up a send.
+var fetchClient = &http.Client{Timeout: 30 * time.Second}
+
+func httpFetch(url string) ([]byte, error) {
+
+	resp, err := fetchClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Request error: %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
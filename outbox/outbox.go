@@ -0,0 +1,315 @@
+// Package outbox queues emails and SMS in a persistent store before they
+// are sent, and delivers them from a background dispatcher with retries.
+//
+// Enqueue returns once the message is stored, so a crash between deciding
+// to send and the API call no longer loses the message. A message is
+// removed from the store only after the API accepted it; delivery is
+// therefore at least once.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+// Sender is the subset of *sib.Client used to deliver messages.
+type Sender interface {
+	SendEmail(e *sib.Email) (sib.EmailResponse, error)
+	SendTemplateEmail(id int, to []string, e *sib.EmailOptions) (sib.EmailResponse, error)
+	SendSMS(s *sib.SMSRequest) (sib.SMSResponse, error)
+}
+
+// Message is a queued send. Exactly one of Email, Template and SMS is set.
+type Message struct {
+	ID         string             `json:"id"`
+	Email      *sib.Email         `json:"email,omitempty"`
+	TemplateID int                `json:"template_id,omitempty"`
+	Template   *sib.TemplateEmail `json:"template,omitempty"`
+	SMS        *sib.SMSRequest    `json:"sms,omitempty"`
+
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	Dead        bool      `json:"dead,omitempty"` // gave up after MaxAttempts
+}
+
+// Stats describes the state of an Outbox.
+type Stats struct {
+	Pending   int // queued and waiting for (another) attempt
+	Dead      int // gave up after MaxAttempts, kept in the store
+	Delivered int // delivered since Start
+	Retried   int // failed attempts since Start
+}
+
+// Outbox queues messages in a Store and delivers them through a Sender.
+type Outbox struct {
+	store  Store
+	sender Sender
+
+	// Interval is how often the store is polled for due messages.
+	Interval time.Duration
+	// MaxAttempts is the number of attempts before a message is marked dead.
+	MaxAttempts int
+	// Backoff returns the delay before the given retry attempt (1-based).
+	Backoff func(attempt int) time.Duration
+	// OnDelivered, if set, is called with each delivered message and
+	// the message-id or SMS reference returned by the API.
+	OnDelivered func(m *Message, messageID string)
+
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	mu        sync.Mutex
+	started   bool
+	delivered int
+	retried   int
+}
+
+// New returns an Outbox with a one second poll interval, five attempts
+// and exponential backoff. Call Start to begin delivering.
+func New(store Store, sender Sender) *Outbox {
+	return &Outbox{
+		store:       store,
+		sender:      sender,
+		Interval:    time.Second,
+		MaxAttempts: 5,
+		Backoff:     ExponentialBackoff(time.Second, 10*time.Minute),
+		wake:        make(chan struct{}, 1),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// ExponentialBackoff doubles the delay from base with every attempt, up to max.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// EnqueueEmail stores a transactional email for delivery.
+func (o *Outbox) EnqueueEmail(e *sib.Email) (string, error) {
+	return o.enqueue(&Message{Email: e})
+}
+
+// EnqueueTemplateEmail stores a template email for delivery.
+func (o *Outbox) EnqueueTemplateEmail(id int, e *sib.TemplateEmail) (string, error) {
+	return o.enqueue(&Message{TemplateID: id, Template: e})
+}
+
+// EnqueueSMS stores an SMS for delivery.
+func (o *Outbox) EnqueueSMS(s *sib.SMSRequest) (string, error) {
+	return o.enqueue(&Message{SMS: s})
+}
+
+func (o *Outbox) enqueue(m *Message) (string, error) {
+
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	m.ID = id
+	m.Created = time.Now()
+	m.NextAttempt = m.Created
+
+	err = o.store.Put(m)
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return id, nil
+}
+
+// Start runs the dispatcher in a new goroutine. It has no effect when
+// the dispatcher is already running or has been shut down.
+func (o *Outbox) Start() {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.started {
+		return
+	}
+	o.started = true
+
+	go o.run()
+}
+
+// Shutdown stops the dispatcher after the message being delivered, if
+// any. Undelivered messages stay in the store for the next Start. It
+// returns ctx.Err() if ctx ends before the dispatcher has stopped.
+func (o *Outbox) Shutdown(ctx context.Context) error {
+
+	o.mu.Lock()
+	started := o.started
+	o.started = true // a later Start must not run after Shutdown
+	o.mu.Unlock()
+
+	o.once.Do(func() { close(o.quit) })
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-o.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the queue depth and delivery counters.
+func (o *Outbox) Stats() (Stats, error) {
+
+	pending, dead, err := o.store.Count()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return Stats{
+		Pending:   pending,
+		Dead:      dead,
+		Delivered: o.delivered,
+		Retried:   o.retried,
+	}, err
+}
+
+func (o *Outbox) run() {
+
+	defer close(o.done)
+
+	for {
+		o.deliverDue()
+
+		select {
+		case <-o.quit:
+			return
+		case <-o.wake:
+		case <-time.After(o.Interval):
+		}
+	}
+}
+
+func (o *Outbox) deliverDue() {
+
+	due, err := o.store.Due(time.Now(), 100)
+	if err != nil {
+		return // the store is retried on the next poll
+	}
+
+	for _, m := range due {
+		select {
+		case <-o.quit:
+			return
+		default:
+		}
+
+		o.deliver(m)
+	}
+}
+
+func (o *Outbox) deliver(m *Message) {
+
+	messageID, err := o.send(m)
+	if err == nil {
+		if o.store.Delete(m.ID) == nil {
+			o.mu.Lock()
+			o.delivered++
+			o.mu.Unlock()
+		}
+		if o.OnDelivered != nil {
+			o.OnDelivered(m, messageID)
+		}
+		return
+	}
+
+	m.Attempts++
+	m.LastError = err.Error()
+	if m.Attempts >= o.MaxAttempts {
+		m.Dead = true
+	} else {
+		m.NextAttempt = time.Now().Add(o.Backoff(m.Attempts))
+	}
+	o.store.Put(m)
+
+	o.mu.Lock()
+	o.retried++
+	o.mu.Unlock()
+}
+
+func (o *Outbox) send(m *Message) (string, error) {
+
+	switch {
+	case m.Email != nil:
+		resp, err := o.sender.SendEmail(m.Email)
+		if err != nil {
+			return "", err
+		}
+		return resp.Data.Message_id, apiError(resp.Code, resp.Message)
+
+	case m.Template != nil:
+		t := m.Template
+		options := &sib.EmailOptions{
+			Cc:             t.Cc,
+			Bcc:            t.Bcc,
+			ReplyTo:        t.ReplyTo,
+			Attr:           t.Attr,
+			Attachment_url: t.Attachment_url,
+			Attachment:     t.Attachment,
+			Headers:        t.Headers,
+		}
+		resp, err := o.sender.SendTemplateEmail(m.TemplateID, strings.Split(t.To, "|"), options)
+		if err != nil {
+			return "", err
+		}
+		return resp.Data.Message_id, apiError(resp.Code, resp.Message)
+
+	case m.SMS != nil:
+		resp, err := o.sender.SendSMS(m.SMS)
+		if err != nil {
+			return "", err
+		}
+		return resp.Data.Reference.One, apiError(resp.Code, resp.Message)
+	}
+
+	return "", fmt.Errorf("Outbox message %s has nothing to send", m.ID)
+}
+
+func apiError(code, message string) error {
+	if code != "success" {
+		return fmt.Errorf("Request error: %s", message)
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("Could not generate message ID: %+v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
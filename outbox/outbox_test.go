@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type fakeSender struct {
+	mu       sync.Mutex
+	failures int // fail this many calls before succeeding
+	emails   []*sib.Email
+	template []string
+	sms      []*sib.SMSRequest
+}
+
+func (f *fakeSender) fail() error {
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("connection refused")
+	}
+	return nil
+}
+
+func (f *fakeSender) SendEmail(e *sib.Email) (sib.EmailResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(); err != nil {
+		return sib.EmailResponse{}, err
+	}
+	f.emails = append(f.emails, e)
+	return sib.EmailResponse{Code: "success", Data: sib.EmailData{Message_id: "<1@example.net>"}}, nil
+}
+
+func (f *fakeSender) SendTemplateEmail(id int, to []string, e *sib.EmailOptions) (sib.EmailResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(); err != nil {
+		return sib.EmailResponse{}, err
+	}
+	f.template = append(f.template, fmt.Sprint(id, to, e.Attr))
+	return sib.EmailResponse{Code: "success"}, nil
+}
+
+func (f *fakeSender) SendSMS(s *sib.SMSRequest) (sib.SMSResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(); err != nil {
+		return sib.SMSResponse{}, err
+	}
+	f.sms = append(f.sms, s)
+	return sib.SMSResponse{Code: "success"}, nil
+}
+
+func (f *fakeSender) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.emails) + len(f.template) + len(f.sms)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the dispatcher.")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOutboxDelivers(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "outbox")
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &fakeSender{failures: 2}
+	o := New(store, sender)
+	o.Interval = 10 * time.Millisecond
+	o.Backoff = func(int) time.Duration { return time.Millisecond }
+
+	email := sib.NewEmail()
+	email.To["ada@example.net"] = "Ada"
+	o.EnqueueEmail(email)
+	o.EnqueueTemplateEmail(7, &sib.TemplateEmail{To: "ada@example.net|bob@example.net", Attr: map[string]string{"A": "1"}})
+	o.EnqueueSMS(&sib.SMSRequest{To: "+3100000001", Text: "hi"})
+
+	stats, _ := o.Stats()
+	if stats.Pending != 3 {
+		t.Errorf("Expected 3 pending messages before Start, got %+v", stats)
+	}
+
+	o.Start()
+	waitFor(t, func() bool { return sender.count() == 3 })
+	waitFor(t, func() bool { s, _ := o.Stats(); return s.Pending == 0 })
+
+	if err := o.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, _ = o.Stats()
+	if stats.Delivered != 3 || stats.Retried != 2 || stats.Dead != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if sender.template[0] != "7 [ada@example.net bob@example.net] map[A:1]" {
+		t.Errorf("Template email is not being converted: %s", sender.template[0])
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+
+	sender := &fakeSender{failures: 100}
+	o := New(NewMemoryStore(), sender)
+	o.Interval = time.Millisecond
+	o.MaxAttempts = 3
+	o.Backoff = func(int) time.Duration { return 0 }
+
+	o.EnqueueSMS(&sib.SMSRequest{To: "+3100000001"})
+	o.Start()
+	defer o.Shutdown(context.Background())
+
+	waitFor(t, func() bool { s, _ := o.Stats(); return s.Dead == 1 })
+
+	stats, _ := o.Stats()
+	if stats.Pending != 0 || stats.Retried != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "outbox")
+	defer os.RemoveAll(dir)
+
+	store, _ := NewFileStore(dir)
+	o := New(store, &fakeSender{})
+	o.EnqueueSMS(&sib.SMSRequest{To: "+3100000001", Text: "hi"})
+	// never started: simulates a crash before delivery
+
+	store, _ = NewFileStore(dir)
+	sender := &fakeSender{}
+	o = New(store, sender)
+	o.Start()
+	waitFor(t, func() bool { return sender.count() == 1 })
+	o.Shutdown(context.Background())
+
+	if sender.sms[0].Text != "hi" {
+		t.Errorf("Message is not being restored from the store: %+v", sender.sms[0])
+	}
+}
+
+func TestFileStoreQuarantinesCorruptFiles(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "outbox")
+	defer os.RemoveAll(dir)
+
+	store, _ := NewFileStore(dir)
+	var reported string
+	store.OnCorrupt = func(file string, err error) { reported = file }
+
+	o := New(store, &fakeSender{})
+	o.EnqueueSMS(&sib.SMSRequest{To: "+3100000001", Text: "hi"})
+	ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{not json"), 0600)
+
+	pending, _, err := store.Count()
+	if err != nil || pending != 1 {
+		t.Errorf("A corrupt file is blocking the store: %d %v", pending, err)
+	}
+	if reported != filepath.Join(dir, "broken.json.corrupt") {
+		t.Errorf("Corrupt files are not being quarantined: %q", reported)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.json.corrupt")); err != nil {
+		t.Error("Corrupt file is not being kept for inspection.")
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+
+	b := ExponentialBackoff(time.Second, 5*time.Second)
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := b(attempt); got != want {
+			t.Errorf("Attempt %d: expected %v, got %v", attempt, want, got)
+		}
+	}
+}
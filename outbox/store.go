@@ -0,0 +1,281 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists queued messages. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Put inserts or replaces a message.
+	Put(m *Message) error
+	// Delete removes a delivered message.
+	Delete(id string) error
+	// Due returns up to limit messages that are not dead and whose
+	// NextAttempt is not after now, oldest first.
+	Due(now time.Time, limit int) ([]*Message, error)
+	// Count returns the number of pending and dead messages.
+	Count() (pending, dead int, err error)
+}
+
+// FileStore keeps every message as a JSON file in a directory. Files are
+// written to a temporary name, synced and renamed, so a crash never
+// leaves a partially written message behind.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+
+	// OnCorrupt is called for each message file that cannot be decoded.
+	// The file is renamed to *.corrupt so that it no longer blocks the
+	// queue. Defaults to logging.
+	OnCorrupt func(file string, err error)
+}
+
+// NewFileStore returns a FileStore in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		err = fmt.Errorf("Could not create outbox directory: %+v", err)
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Put implements Store.
+func (s *FileStore) Put(m *Message) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		err = fmt.Errorf("Could not marshal JSON: %+v", err)
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		err = fmt.Errorf("Could not write outbox message: %+v", err)
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path(m.ID))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		err = fmt.Errorf("Could not write outbox message: %+v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(id string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("Could not delete outbox message: %+v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Due implements Store.
+func (s *FileStore) Due(now time.Time, limit int) ([]*Message, error) {
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []*Message
+	for _, m := range all {
+		if !m.Dead && !m.NextAttempt.After(now) {
+			due = append(due, m)
+		}
+	}
+
+	return oldest(due, limit), nil
+}
+
+// Count implements Store.
+func (s *FileStore) Count() (pending, dead int, err error) {
+
+	all, err := s.load()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, m := range all {
+		if m.Dead {
+			dead++
+		} else {
+			pending++
+		}
+	}
+
+	return pending, dead, nil
+}
+
+func (s *FileStore) load() ([]*Message, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		err = fmt.Errorf("Could not read outbox directory: %+v", err)
+		return nil, err
+	}
+
+	var all []*Message
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("Could not read outbox message: %+v", err)
+			return nil, err
+		}
+
+		m := &Message{}
+		err = json.Unmarshal(b, m)
+		if err != nil {
+			s.quarantine(name, err)
+			continue
+		}
+		all = append(all, m)
+	}
+
+	return all, nil
+}
+
+// quarantine moves a message file that cannot be decoded out of the
+// queue and reports it.
+func (s *FileStore) quarantine(name string, err error) {
+
+	file := filepath.Join(s.dir, name)
+	if rerr := os.Rename(file, file+".corrupt"); rerr == nil {
+		file += ".corrupt"
+	}
+
+	err = fmt.Errorf("Could not decode outbox message %s: %+v", name, err)
+	if s.OnCorrupt != nil {
+		s.OnCorrupt(file, err)
+		return
+	}
+	log.Printf("outbox: %v; moved to %s", err, file)
+}
+
+// MemoryStore keeps messages in memory. It is not durable and is meant
+// for tests and for processes that only need the retrying dispatcher.
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages map[string]Message
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{messages: make(map[string]Message)}
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(m *Message) error {
+	s.mu.Lock()
+	s.messages[m.ID] = *m
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	delete(s.messages, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Due implements Store.
+func (s *MemoryStore) Due(now time.Time, limit int) ([]*Message, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*Message
+	for _, m := range s.messages {
+		if !m.Dead && !m.NextAttempt.After(now) {
+			c := m
+			due = append(due, &c)
+		}
+	}
+
+	return oldest(due, limit), nil
+}
+
+// Count implements Store.
+func (s *MemoryStore) Count() (pending, dead int, err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.messages {
+		if m.Dead {
+			dead++
+		} else {
+			pending++
+		}
+	}
+
+	return pending, dead, nil
+}
+
+func oldest(messages []*Message, limit int) []*Message {
+
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Created.Equal(messages[j].Created) {
+			return messages[i].ID < messages[j].ID
+		}
+		return messages[i].Created.Before(messages[j].Created)
+	})
+
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages
+}
@@ -0,0 +1,237 @@
+package sib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BuiltinPlaceholders are replaced by SendInBlue itself and never need
+// a value in EmailOptions.Attr.
+var BuiltinPlaceholders = map[string]bool{
+	"EMAIL":          true,
+	"MIRROR":         true,
+	"UNSUBSCRIBE":    true,
+	"UPDATE_PROFILE": true,
+}
+
+var (
+	percentPlaceholder = regexp.MustCompile(`^%([A-Za-z][A-Za-z0-9_]*)%`)
+	bracePlaceholder   = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+	placeholderName    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Placeholders returns the attribute names used in a template, both in
+// the %ATTR% and the {{ ATTR }} / {{ params.ATTR }} syntax. Names are
+// upper-cased, deduplicated and sorted. Built-in placeholders and
+// {{{inline images}}} are left out.
+func Placeholders(content string) []string {
+
+	var names []string
+	for name := range placeholderOffsets(content) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// placeholderOffsets maps the names Placeholders returns to the byte
+// offset of their first use.
+func placeholderOffsets(content string) map[string]int {
+
+	offsets := make(map[string]int)
+	see := func(name string, offset int) {
+		name = strings.ToUpper(name)
+		if BuiltinPlaceholders[name] {
+			return
+		}
+		if o, ok := offsets[name]; !ok || offset < o {
+			offsets[name] = offset
+		}
+	}
+
+	for _, m := range findPercentPlaceholders(content) {
+		see(content[m[2]:m[3]], m[0])
+	}
+
+	for _, m := range bracePlaceholder.FindAllStringSubmatchIndex(content, -1) {
+		if m[0] > 0 && content[m[0]-1] == '{' {
+			continue // {{{inline_image}}}
+		}
+
+		name := content[m[2]:m[3]]
+		if i := strings.Index(name, "|"); i >= 0 {
+			name = strings.TrimSpace(name[:i]) // {{ params.NAME | default:"you" }}
+		}
+		name = strings.TrimPrefix(name, "params.")
+		name = strings.TrimPrefix(name, "contact.")
+
+		if placeholderName.MatchString(name) {
+			see(name, m[0])
+		}
+	}
+
+	return offsets
+}
+
+// findPercentPlaceholders returns the submatch indexes of the %NAME%
+// placeholders in s. A name of two hex digits is URL percent-encoding,
+// as in caf%C3%A9, and only its first % is skipped so that a placeholder
+// right after it is still found.
+func findPercentPlaceholders(s string) [][]int {
+
+	var found [][]int
+	for i := 0; i < len(s); {
+		p := strings.IndexByte(s[i:], '%')
+		if p < 0 {
+			break
+		}
+		p += i
+
+		m := percentPlaceholder.FindStringSubmatchIndex(s[p:])
+		if m == nil || isPercentEncoding(s[p+m[2]:p+m[3]]) {
+			i = p + 1
+			continue
+		}
+		for j := range m {
+			m[j] += p
+		}
+		found = append(found, m)
+		i = m[1]
+	}
+
+	return found
+}
+
+func isPercentEncoding(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingAttrError is returned by SendTemplateEmail in strict mode when
+// the template uses attributes that have no value in EmailOptions.Attr.
+type MissingAttrError struct {
+	TemplateID int
+	Missing    []string
+}
+
+func (e *MissingAttrError) Error() string {
+	return fmt.Sprintf("Template %d is missing attributes: %s", e.TemplateID, strings.Join(e.Missing, ", "))
+}
+
+// CheckAttr compares the placeholders of a template's content against
+// the attributes that will be sent. It returns the placeholders without
+// a value and the attributes that the template does not use.
+func CheckAttr(content string, attr map[string]string) (missing, unused []string) {
+
+	given := make(map[string]string)
+	for k := range attr {
+		given[strings.ToUpper(k)] = k
+	}
+
+	used := make(map[string]bool)
+	for _, name := range Placeholders(content) {
+		used[name] = true
+		if _, ok := given[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	for upper, k := range given {
+		if !used[upper] {
+			unused = append(unused, k)
+		}
+	}
+	sort.Strings(unused)
+
+	return missing, unused
+}
+
+// WithStrictTemplates makes SendTemplateEmail fetch the template via
+// GetTemplate and refuse to send when an attribute used by its subject
+// or HTML content is missing from EmailOptions.Attr. Attributes the
+// template does not use are passed to warn, which may be nil.
+// Template content is cached until UpdateTemplate is called for it.
+func WithStrictTemplates(warn func(templateID int, unused []string)) Option {
+	return func(c *Client) {
+		c.strict = &templateCheck{
+			warn:    warn,
+			content: make(map[int]string),
+		}
+	}
+}
+
+type templateCheck struct {
+	warn func(templateID int, unused []string)
+
+	mu      sync.Mutex
+	content map[int]string
+}
+
+func (t *templateCheck) check(c *Client, id int, attr map[string]string) error {
+
+	content, err := t.lookup(c, id)
+	if err != nil {
+		return err
+	}
+
+	missing, unused := CheckAttr(content, attr)
+	if len(missing) > 0 {
+		return &MissingAttrError{TemplateID: id, Missing: missing}
+	}
+	if len(unused) > 0 && t.warn != nil {
+		t.warn(id, unused)
+	}
+
+	return nil
+}
+
+func (t *templateCheck) lookup(c *Client, id int) (string, error) {
+
+	t.mu.Lock()
+	content, ok := t.content[id]
+	t.mu.Unlock()
+	if ok {
+		return content, nil
+	}
+
+	resp, err := c.GetTemplate(id)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Data) == 0 {
+		err := fmt.Errorf("Could not fetch template %d for attribute check: %s", id, resp.Message)
+		return "", err
+	}
+
+	return t.remember(id, &resp.Data[0]), nil
+}
+
+// remember caches the content of a template, which may have been fetched
+// elsewhere, and returns it.
+func (t *templateCheck) remember(id int, template *CampaignData) string {
+
+	content := template.Subject + "\n" + template.Html_content
+
+	t.mu.Lock()
+	t.content[id] = content
+	t.mu.Unlock()
+
+	return content
+}
+
+func (t *templateCheck) forget(id int) {
+	t.mu.Lock()
+	delete(t.content, id)
+	t.mu.Unlock()
+}
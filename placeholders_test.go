@@ -0,0 +1,103 @@
+package sib
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholders(t *testing.T) {
+
+	html := `<p style="width:100%">Hi %FIRSTNAME% {{ params.lastname }},</p>
+<p>{{ contact.CITY | default:"there" }} {{PLAN}} %firstname%</p>
+<img src="{{{logo.png}}}"> <a href="%UNSUBSCRIBE%">unsubscribe</a> {{ mirror }}`
+
+	got := Placeholders(html)
+	want := []string{"CITY", "FIRSTNAME", "LASTNAME", "PLAN"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected placeholders %v, got %v", want, got)
+	}
+}
+
+func TestPlaceholdersIgnoreURLEncoding(t *testing.T) {
+
+	html := `<a href="https://example.com/caf%C3%A9?q=%E2%80%99">x</a> caf%C3%FIRSTNAME% 100%ab%`
+
+	got := Placeholders(html)
+	want := []string{"FIRSTNAME"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("URL percent-encoding is being read as placeholders: %v", got)
+	}
+}
+
+func TestCheckAttr(t *testing.T) {
+
+	missing, unused := CheckAttr("Hello %FIRSTNAME% %LASTNAME%", map[string]string{
+		"firstname": "Ada",
+		"PLAN":      "pro",
+	})
+
+	if !reflect.DeepEqual(missing, []string{"LASTNAME"}) {
+		t.Errorf("Expected LASTNAME to be missing, got %v", missing)
+	}
+	if !reflect.DeepEqual(unused, []string{"PLAN"}) {
+		t.Errorf("Expected PLAN to be unused, got %v", unused)
+	}
+}
+
+func TestStrictTemplates(t *testing.T) {
+
+	var warned []string
+	client, _ := NewClient("123", WithStrictTemplates(func(id int, unused []string) {
+		warned = unused
+	}))
+
+	gets, sends := 0, 0
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == "GET" {
+			gets++
+			return jsonResponse(`{"code":"success","data":[{"id":7,"subject":"Hi %FIRSTNAME%","html_content":"<p>{{ params.PLAN }}</p>"}]}`), nil
+		}
+		sends++
+		return jsonResponse(`{"code":"success","data":{"message-id":"<1@example.net>"}}`), nil
+	})
+
+	options := NewEmailOptions("", "", nil, nil)
+	options.Attr["FIRSTNAME"] = "Ada"
+
+	_, err := client.SendTemplateEmail(7, []string{"ada@example.net"}, options)
+	if _, ok := err.(*MissingAttrError); !ok {
+		t.Fatalf("Expected a MissingAttrError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "PLAN") {
+		t.Errorf("Error does not name the missing attribute: %v", err)
+	}
+	if sends != 0 {
+		t.Error("Email was sent despite missing attributes.")
+	}
+
+	options.Attr["PLAN"] = "pro"
+	options.Attr["EXTRA"] = "x"
+	resp, err := client.SendTemplateEmail(7, []string{"ada@example.net"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Message_id == "" || sends != 1 {
+		t.Error("Email was not sent once attributes were complete.")
+	}
+	if !reflect.DeepEqual(warned, []string{"EXTRA"}) {
+		t.Errorf("Expected a warning about EXTRA, got %v", warned)
+	}
+	if gets != 1 {
+		t.Errorf("Expected template content to be cached, fetched %d times", gets)
+	}
+
+	client.UpdateTemplate(7, &Template{})
+	client.SendTemplateEmail(7, []string{"ada@example.net"}, options)
+	if gets != 2 {
+		t.Error("UpdateTemplate is not invalidating the template cache.")
+	}
+}
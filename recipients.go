@@ -0,0 +1,254 @@
+package sib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Headers recording the recipients replaced by a RecipientPolicy.
+const (
+	OriginalToHeader  = "X-Original-To"
+	OriginalCcHeader  = "X-Original-Cc"
+	OriginalBccHeader = "X-Original-Bcc"
+)
+
+// A RecipientPolicy rewrites the recipients of every email and SMS sent
+// by a Client, so that non-production environments cannot reach real
+// customers. Recipients on an allowlist pass through unchanged; all
+// others are replaced by the redirect address or number, or dropped when
+// no redirect is configured. The replaced email recipients are kept in
+// the X-Original-To, X-Original-Cc and X-Original-Bcc headers.
+type RecipientPolicy struct {
+	RedirectEmail string   // safe address for email recipients
+	RedirectSMS   string   // safe mobile number for SMS recipients
+	AllowDomains  []string // email domains (and their subdomains) left unchanged
+	AllowNumbers  []string // mobile numbers left unchanged
+}
+
+// WithRecipientPolicy applies p to SendEmail, SendTemplateEmail, SendSMS and
+// SMSCampaignTest.
+func WithRecipientPolicy(p *RecipientPolicy) Option {
+	return func(c *Client) {
+		c.recipients = p
+	}
+}
+
+// AllowsEmail reports whether addr is on the domain allowlist.
+func (p *RecipientPolicy) AllowsEmail(addr string) bool {
+
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return false
+	}
+	domain := strings.ToLower(strings.TrimSpace(addr[i+1:]))
+
+	for _, d := range p.AllowDomains {
+		d = strings.ToLower(strings.TrimPrefix(d, "@"))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsNumber reports whether number is on the number allowlist.
+// Spaces, dashes, dots and parentheses are ignored in the comparison.
+func (p *RecipientPolicy) AllowsNumber(number string) bool {
+
+	n := normalizeNumber(number)
+	for _, a := range p.AllowNumbers {
+		if n != "" && normalizeNumber(a) == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RewriteEmail returns a copy of e with its To, CC and Bcc rewritten.
+// It fails when no recipient would be left.
+func (p *RecipientPolicy) RewriteEmail(e *Email) (*Email, error) {
+
+	out := *e
+	out.Headers = copyMap(e.Headers)
+
+	var removed [3][]string
+	out.To, removed[0] = p.rewriteMap(e.To)
+	out.CC, removed[1] = p.rewriteMap(e.CC)
+	out.Bcc, removed[2] = p.rewriteMap(e.Bcc)
+
+	// the redirect address gets a single copy
+	if _, ok := out.To[p.RedirectEmail]; ok && p.RedirectEmail != "" {
+		delete(out.CC, p.RedirectEmail)
+		delete(out.Bcc, p.RedirectEmail)
+	} else if _, ok := out.CC[p.RedirectEmail]; ok && p.RedirectEmail != "" {
+		delete(out.Bcc, p.RedirectEmail)
+	}
+
+	if len(out.To)+len(out.CC)+len(out.Bcc) == 0 {
+		err := fmt.Errorf("Recipient policy removed every recipient")
+		return nil, err
+	}
+
+	p.recordOriginal(&out.Headers, removed)
+
+	return &out, nil
+}
+
+// RewriteTemplateEmail rewrites the pipe-delimited To, Cc and Bcc of e in
+// place. It fails when no recipient would be left.
+func (p *RecipientPolicy) RewriteTemplateEmail(e *TemplateEmail) error {
+
+	var removed [3][]string
+	e.To, removed[0] = p.rewriteList(e.To)
+	e.Cc, removed[1] = p.rewriteList(e.Cc)
+	e.Bcc, removed[2] = p.rewriteList(e.Bcc)
+
+	// the redirect address gets a single copy, as in RewriteEmail
+	if p.RedirectEmail != "" {
+		if inList(e.To, p.RedirectEmail) {
+			e.Cc = removeFromList(e.Cc, p.RedirectEmail)
+			e.Bcc = removeFromList(e.Bcc, p.RedirectEmail)
+		} else if inList(e.Cc, p.RedirectEmail) {
+			e.Bcc = removeFromList(e.Bcc, p.RedirectEmail)
+		}
+	}
+
+	if e.To == "" && e.Cc == "" && e.Bcc == "" {
+		err := fmt.Errorf("Recipient policy removed every recipient")
+		return err
+	}
+
+	e.Headers = copyMap(e.Headers)
+	p.recordOriginal(&e.Headers, removed)
+
+	return nil
+}
+
+// RewriteSMS returns a copy of s sent to the redirect number unless its
+// recipient is allowed. SMS have no headers, so the original number is
+// not kept.
+func (p *RecipientPolicy) RewriteSMS(s *SMSRequest) (*SMSRequest, error) {
+
+	out := *s
+	if p.AllowsNumber(s.To) {
+		return &out, nil
+	}
+	if p.RedirectSMS == "" {
+		err := fmt.Errorf("Recipient policy removed every recipient")
+		return nil, err
+	}
+
+	out.To = p.RedirectSMS
+	return &out, nil
+}
+
+func (p *RecipientPolicy) rewriteMap(m map[string]string) (map[string]string, []string) {
+
+	if m == nil {
+		return nil, nil
+	}
+
+	out := make(map[string]string)
+	var removed []string
+
+	for addr, name := range m {
+		if p.AllowsEmail(addr) {
+			out[addr] = name
+			continue
+		}
+		removed = append(removed, addr)
+		if p.RedirectEmail != "" {
+			out[p.RedirectEmail] = "Redirected"
+		}
+	}
+	sort.Strings(removed)
+
+	return out, removed
+}
+
+func (p *RecipientPolicy) rewriteList(list string) (string, []string) {
+
+	var kept, removed []string
+	seen := make(map[string]bool)
+	keep := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			kept = append(kept, addr)
+		}
+	}
+
+	for _, addr := range strings.Split(list, "|") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if p.AllowsEmail(addr) {
+			keep(addr)
+			continue
+		}
+		removed = append(removed, addr)
+		if p.RedirectEmail != "" {
+			keep(p.RedirectEmail)
+		}
+	}
+	sort.Strings(removed)
+
+	return strings.Join(kept, "|"), removed
+}
+
+func inList(list, addr string) bool {
+	for _, a := range strings.Split(list, "|") {
+		if strings.TrimSpace(a) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFromList(list, addr string) string {
+	var kept []string
+	for _, a := range strings.Split(list, "|") {
+		if a = strings.TrimSpace(a); a != "" && a != addr {
+			kept = append(kept, a)
+		}
+	}
+	return strings.Join(kept, "|")
+}
+
+func (p *RecipientPolicy) recordOriginal(headers *map[string]string, removed [3][]string) {
+
+	names := [3]string{OriginalToHeader, OriginalCcHeader, OriginalBccHeader}
+	for i, list := range removed {
+		if len(list) == 0 {
+			continue
+		}
+		if *headers == nil {
+			*headers = make(map[string]string)
+		}
+		(*headers)[names[i]] = strings.Join(list, ", ")
+	}
+}
+
+func normalizeNumber(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
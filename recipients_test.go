@@ -0,0 +1,113 @@
+package sib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+var stagingPolicy = &RecipientPolicy{
+	RedirectEmail: "qa@example.org",
+	RedirectSMS:   "+31000000000",
+	AllowDomains:  []string{"example.org"},
+	AllowNumbers:  []string{"+31 6 1234-5678"},
+}
+
+func TestRewriteEmail(t *testing.T) {
+
+	email := NewEmail()
+	email.To["customer@example.net"] = "Customer"
+	email.To["dev@team.example.org"] = "Dev"
+	email.CC["boss@example.net"] = "Boss"
+	email.Bcc["audit@example.net"] = "Audit"
+
+	out, err := stagingPolicy.RewriteEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.To) != 2 || out.To["qa@example.org"] == "" || out.To["dev@team.example.org"] != "Dev" {
+		t.Errorf("To is not being rewritten: %v", out.To)
+	}
+	if len(out.CC) != 0 || len(out.Bcc) != 0 {
+		t.Errorf("Redirected CC and Bcc should collapse into To: %v %v", out.CC, out.Bcc)
+	}
+	if out.Headers[OriginalToHeader] != "customer@example.net" || out.Headers[OriginalCcHeader] != "boss@example.net" || out.Headers[OriginalBccHeader] != "audit@example.net" {
+		t.Errorf("Original recipients are not being kept: %v", out.Headers)
+	}
+	if _, ok := email.To["qa@example.org"]; ok || len(email.Headers) != 0 {
+		t.Error("The caller's email is being modified.")
+	}
+
+	dropAll := &RecipientPolicy{AllowDomains: []string{"example.org"}}
+	if _, err := dropAll.RewriteEmail(email); err != nil {
+		t.Errorf("Expected allowed recipients to be kept: %v", err)
+	}
+	delete(email.To, "dev@team.example.org")
+	if _, err := dropAll.RewriteEmail(email); err == nil {
+		t.Error("Expected an email without any allowed recipient to fail.")
+	}
+}
+
+func TestRewriteTemplateEmail(t *testing.T) {
+
+	e := &TemplateEmail{To: "a@example.net|b@example.org|c@example.net", Cc: "d@example.net"}
+	if err := stagingPolicy.RewriteTemplateEmail(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.To != "qa@example.org|b@example.org" || e.Cc != "" {
+		t.Errorf("Recipients are not being rewritten: %q %q", e.To, e.Cc)
+	}
+	if e.Headers[OriginalToHeader] != "a@example.net, c@example.net" {
+		t.Errorf("Original recipients are not being kept: %v", e.Headers)
+	}
+}
+
+func TestRewriteSMS(t *testing.T) {
+
+	out, _ := stagingPolicy.RewriteSMS(&SMSRequest{To: "+31612345678"})
+	if out.To != "+31612345678" {
+		t.Errorf("Allowed number is being rewritten: %s", out.To)
+	}
+
+	out, _ = stagingPolicy.RewriteSMS(&SMSRequest{To: "+44700000000"})
+	if out.To != "+31000000000" {
+		t.Errorf("Number is not being redirected: %s", out.To)
+	}
+}
+
+func TestClientRecipientPolicy(t *testing.T) {
+
+	client, _ := NewClient("123", WithRecipientPolicy(stagingPolicy))
+
+	var sent map[string]interface{}
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &sent)
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	client.SendTemplateEmail(1, []string{"customer@example.net"}, nil)
+	if sent["to"] != "qa@example.org" {
+		t.Errorf("SendTemplateEmail is not applying the policy: %v", sent["to"])
+	}
+
+	client.SendSMS(&SMSRequest{To: "+44700000000"})
+	if sent["to"] != "+31000000000" {
+		t.Errorf("SendSMS is not applying the policy: %v", sent["to"])
+	}
+
+	client.SMSCampaignTest(3, "+44700000000")
+	if sent["to"] != "+31000000000" {
+		t.Errorf("SMSCampaignTest is not applying the policy: %v", sent["to"])
+	}
+
+	email := NewEmail()
+	email.To["customer@example.net"] = "Customer"
+	client.SendEmail(email)
+	if to := sent["to"].(map[string]interface{}); to["qa@example.org"] == nil {
+		t.Errorf("SendEmail is not applying the policy: %v", to)
+	}
+}
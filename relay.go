@@ -0,0 +1,242 @@
+package sib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"time"
+)
+
+// SMTPRelayAddr is the address of SendInBlue's SMTP relay.
+const SMTPRelayAddr = "smtp-relay.sendinblue.com:587"
+
+// An EmailSender sends an Email. Both *Client and *SMTPTransport are
+// EmailSenders.
+type EmailSender interface {
+	SendEmail(e *Email) (EmailResponse, error)
+}
+
+// SMTPTransport delivers emails as MIME messages over the SMTP relay.
+// The connection is always upgraded with STARTTLS before authenticating.
+type SMTPTransport struct {
+	Addr     string // defaults to SMTPRelayAddr
+	Username string // the account login
+	Password string // the SMTP key, not the API key
+
+	TLSConfig *tls.Config   // defaults to verifying the relay host name
+	Timeout   time.Duration // dial timeout, defaults to 30s
+
+	// Fetch loads URL attachments. Defaults to an HTTP GET.
+	Fetch func(url string) ([]byte, error)
+}
+
+// SendEmail implements EmailSender. The response carries the generated
+// Message-Id.
+func (t *SMTPTransport) SendEmail(e *Email) (EmailResponse, error) {
+
+	var response EmailResponse
+
+	fetch := t.Fetch
+	if fetch == nil {
+		fetch = httpFetch
+	}
+	msg, err := newMIMEMessage(e, fetch)
+	if err != nil {
+		return response, err
+	}
+
+	var body bytes.Buffer
+	if _, err := msg.WriteTo(&body); err != nil {
+		err = fmt.Errorf("Could not write MIME message: %+v", err)
+		return response, err
+	}
+
+	var rcpt []string
+	for _, m := range []map[string]string{e.To, e.CC, e.Bcc} {
+		rcpt = append(rcpt, sortedKeys(m)...)
+	}
+	if len(rcpt) == 0 {
+		return response, fmt.Errorf("Email has no recipients")
+	}
+
+	err = t.send(e.From[0], rcpt, body.Bytes())
+	if err != nil {
+		return response, err
+	}
+
+	response.Code = "success"
+	response.Message = "Email sent through the SMTP relay"
+	response.Data.Message_id = msg.messageID
+
+	return response, nil
+}
+
+func (t *SMTPTransport) send(from string, rcpt []string, body []byte) error {
+
+	addr := t.Addr
+	if addr == "" {
+		addr = SMTPRelayAddr
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		err = fmt.Errorf("Could not parse SMTP address: %+v", err)
+		return err
+	}
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		err = fmt.Errorf("Could not connect to SMTP relay: %+v", err)
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		err = fmt.Errorf("Could not connect to SMTP relay: %+v", err)
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		return fmt.Errorf("SMTP relay does not support STARTTLS")
+	}
+	config := t.TLSConfig
+	if config == nil {
+		config = &tls.Config{ServerName: host}
+	}
+	if err := c.StartTLS(config); err != nil {
+		err = fmt.Errorf("Could not start TLS: %+v", err)
+		return err
+	}
+
+	if t.Username != "" {
+		err := c.Auth(smtp.PlainAuth("", t.Username, t.Password, host))
+		if err != nil {
+			err = fmt.Errorf("Could not authenticate: %+v", err)
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		err = fmt.Errorf("Sender rejected: %+v", err)
+		return err
+	}
+	for _, r := range rcpt {
+		if err := c.Rcpt(r); err != nil {
+			err = fmt.Errorf("Recipient %s rejected: %+v", r, err)
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		err = fmt.Errorf("Could not send message: %+v", err)
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		err = fmt.Errorf("Could not send message: %+v", err)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		err = fmt.Errorf("Message rejected: %+v", err)
+		return err
+	}
+
+	return c.Quit()
+}
+
+// FailoverSender sends through Primary, normally the API Client, and
+// falls back to Fallback, normally an SMTPTransport, when the primary
+// could not reach the API: a transport error, a 5xx status or an open
+// circuit breaker. Responses with a "failure" code are returned as-is,
+// since the relay would reject the same email. An *ArchiveError, or a
+// response that cannot be decoded after a 2xx status, never falls back,
+// since the email may already have been sent.
+//
+// When Primary is a *Client, the fallback gets the email as the Client
+// would have sent it, with its recipient policy, HTML filters, link
+// parameters and generated text applied, and is archived by the Client.
+// A sandboxed Client never falls back.
+type FailoverSender struct {
+	Primary  EmailSender
+	Fallback EmailSender
+
+	// ShouldFallback overrides the default decision.
+	ShouldFallback func(resp EmailResponse, err error) bool
+
+	// OnFallback, when set, is called with the primary's error before
+	// falling back.
+	OnFallback func(err error)
+}
+
+// shouldFallback reports whether err shows that the primary did not
+// reach the API.
+func shouldFallback(err error) bool {
+
+	var archiveErr *ArchiveError
+	if err == nil || errors.As(err, &archiveErr) {
+		return false
+	}
+
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= 500
+	}
+
+	var openErr *CircuitOpenError
+	var urlErr *url.Error
+	return errors.As(err, &openErr) || errors.As(err, &urlErr)
+}
+
+// SendEmail implements EmailSender.
+func (f *FailoverSender) SendEmail(e *Email) (EmailResponse, error) {
+
+	resp, err := f.Primary.SendEmail(e)
+
+	client, _ := f.Primary.(*Client)
+	if client != nil && client.sandbox != nil {
+		return resp, err
+	}
+
+	fallback := shouldFallback(err)
+	if f.ShouldFallback != nil {
+		fallback = f.ShouldFallback(resp, err)
+	}
+	if !fallback {
+		return resp, err
+	}
+
+	if f.OnFallback != nil {
+		f.OnFallback(err)
+	}
+
+	if client != nil {
+		prepared, perr := client.prepareEmail(e)
+		if perr != nil {
+			perr = fmt.Errorf("Primary failed (%v) and fallback failed: %w", err, perr)
+			return EmailResponse{}, perr
+		}
+		e = prepared
+	}
+
+	resp, ferr := f.Fallback.SendEmail(e)
+	if ferr != nil {
+		ferr = fmt.Errorf("Primary failed (%v) and fallback failed: %w", err, ferr)
+		return resp, ferr
+	}
+
+	if client != nil {
+		if err := client.archiveEmail(resp, e); err != nil {
+			return resp, err
+		}
+	}
+
+	return resp, nil
+}
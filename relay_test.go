@@ -0,0 +1,406 @@
+package sib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub is a minimal SMTP server with STARTTLS and AUTH PLAIN that
+// records the last message it accepted.
+type smtpStub struct {
+	ln     net.Listener
+	config *tls.Config
+	pool   *x509.CertPool
+
+	mu   sync.Mutex
+	tls  bool
+	auth string
+	from string
+	rcpt []string
+	data []byte
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stub"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	s := &smtpStub{
+		config: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		pool:   x509.NewCertPool(),
+	}
+	s.pool.AddCert(cert)
+
+	s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { s.ln.Close() })
+
+	return s
+}
+
+func (s *smtpStub) transport() *SMTPTransport {
+	return &SMTPTransport{
+		Addr:      s.ln.Addr().String(),
+		Username:  "user",
+		Password:  "pass",
+		TLSConfig: &tls.Config{RootCAs: s.pool, ServerName: "127.0.0.1"},
+	}
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	secure := false
+
+	reply("220 stub ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO":
+			if secure {
+				reply("250-stub\r\n250 AUTH PLAIN")
+			} else {
+				reply("250-stub\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			reply("220 ready")
+			tc := tls.Server(conn, s.config)
+			if tc.Handshake() != nil {
+				return
+			}
+			conn, r, secure = tc, bufio.NewReader(tc), true
+			s.mu.Lock()
+			s.tls = true
+			s.mu.Unlock()
+		case "AUTH":
+			fields := strings.Fields(line)
+			b, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			s.mu.Lock()
+			s.auth = string(b)
+			s.mu.Unlock()
+			if string(b) != "\x00user\x00pass" {
+				reply("535 bad credentials")
+				continue
+			}
+			reply("235 ok")
+		case "MAIL":
+			s.mu.Lock()
+			s.from, s.rcpt = strings.Trim(line[len("MAIL FROM:"):], "<>"), nil
+			s.mu.Unlock()
+			reply("250 ok")
+		case "RCPT":
+			s.mu.Lock()
+			s.rcpt = append(s.rcpt, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			s.mu.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data bytes.Buffer
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.mu.Lock()
+			s.data = data.Bytes()
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func testRelayEmail() *Email {
+	e := NewEmail()
+	e.To = map[string]string{"to@example.com": "Tö Person"}
+	e.Subject = "Héllo"
+	e.From = [2]string{"from@example.com", "From"}
+	e.HTML = `<p>Hi</p><img src="{{{./test/logo.png}}}">`
+	e.Text = "Hi"
+	e.CC = map[string]string{"cc@example.com": ""}
+	e.Bcc = map[string]string{"bcc@example.com": ""}
+	e.Attachment["report.txt"] = base64.StdEncoding.EncodeToString([]byte("report body"))
+	e.Headers["X-Campaign"] = "spring"
+	e.Inline_image["./test/logo.png"] = base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n"))
+	return e
+}
+
+func TestSMTPTransportSendEmail(t *testing.T) {
+
+	stub := newSMTPStub(t)
+
+	resp, err := stub.transport().SendEmail(testRelayEmail())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != "success" || resp.Data.Message_id == "" {
+		t.Error("SMTP response is not being filled in.")
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	if !stub.tls {
+		t.Error("STARTTLS is not being used.")
+	}
+	if stub.auth != "\x00user\x00pass" {
+		t.Error("Credentials are not being sent.")
+	}
+	if stub.from != "from@example.com" {
+		t.Errorf("Envelope sender is not being set: %q", stub.from)
+	}
+	if strings.Join(stub.rcpt, ",") != "to@example.com,cc@example.com,bcc@example.com" {
+		t.Errorf("Envelope recipients are not being set: %v", stub.rcpt)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(stub.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := new(mime.WordDecoder)
+	if subj, _ := dec.DecodeHeader(msg.Header.Get("Subject")); subj != "Héllo" {
+		t.Errorf("Subject is not being encoded: %q", subj)
+	}
+	if msg.Header.Get("Bcc") != "" {
+		t.Error("Bcc is being leaked into the headers.")
+	}
+	if msg.Header.Get("X-Campaign") != "spring" {
+		t.Error("Custom headers are not being copied.")
+	}
+	if msg.Header.Get("Message-Id") != resp.Data.Message_id {
+		t.Error("Message-Id is not being returned.")
+	}
+
+	_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	mixed := multipart.NewReader(msg.Body, params["boundary"])
+
+	related, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ = mime.ParseMediaType(related.Header.Get("Content-Type"))
+	rr := multipart.NewReader(related, params["boundary"])
+
+	alt, _ := rr.NextPart()
+	_, params, _ = mime.ParseMediaType(alt.Header.Get("Content-Type"))
+	ar := multipart.NewReader(alt, params["boundary"])
+	text, _ := ar.NextPart()
+	b, _ := ioutil.ReadAll(text)
+	if string(b) != "Hi" {
+		t.Errorf("Text part is not being written: %q", b)
+	}
+	html, _ := ar.NextPart()
+	b, _ = ioutil.ReadAll(html)
+
+	img, _ := rr.NextPart()
+	cid := strings.Trim(img.Header.Get("Content-Id"), "<>")
+	if !strings.Contains(string(b), `src="cid:`+cid+`"`) {
+		t.Errorf("Inline image is not being referenced by CID: %s", b)
+	}
+
+	att, _ := mixed.NextPart()
+	if att.FileName() != "report.txt" {
+		t.Errorf("Attachment is not being named: %q", att.FileName())
+	}
+	b, _ = ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, att))
+	if string(b) != "report body" {
+		t.Errorf("Attachment is not being encoded: %q", b)
+	}
+}
+
+func TestSMTPTransportAuthFailure(t *testing.T) {
+
+	stub := newSMTPStub(t)
+	tr := stub.transport()
+	tr.Password = "wrong"
+
+	_, err := tr.SendEmail(testRelayEmail())
+	if err == nil {
+		t.Error("Authentication failures are not being reported.")
+	}
+}
+
+type senderFunc func(e *Email) (EmailResponse, error)
+
+func (f senderFunc) SendEmail(e *Email) (EmailResponse, error) { return f(e) }
+
+func TestFailoverSender(t *testing.T) {
+
+	stub := newSMTPStub(t)
+	down := senderFunc(func(*Email) (EmailResponse, error) {
+		return EmailResponse{}, &url.Error{Op: "Post", URL: "https://api.sendinblue.com", Err: errors.New("connection refused")}
+	})
+
+	var reported error
+	f := &FailoverSender{
+		Primary:    down,
+		Fallback:   stub.transport(),
+		OnFallback: func(err error) { reported = err },
+	}
+	resp, err := f.SendEmail(testRelayEmail())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reported == nil || resp.Data.Message_id == "" {
+		t.Error("Failover is not falling back to SMTP.")
+	}
+
+	rejected := senderFunc(func(*Email) (EmailResponse, error) {
+		return EmailResponse{Code: "failure", Message: "bad address"}, nil
+	})
+	f.Primary = rejected
+	resp, _ = f.SendEmail(testRelayEmail())
+	if resp.Code != "failure" {
+		t.Error("API rejections are falling back to SMTP.")
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Record(SandboxRequest) error { return errors.New("sink down") }
+
+func TestFailoverSenderKeepsSentEmails(t *testing.T) {
+
+	stub := newSMTPStub(t)
+	client, _ := NewClient("123", WithArchive(failingArchive{}))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"code":"success","data":{"message-id":"<1@x>"}}`)),
+		}, nil
+	})
+
+	f := &FailoverSender{Primary: client, Fallback: stub.transport()}
+	_, err := f.SendEmail(testRelayEmail())
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) {
+		t.Errorf("Expected an ArchiveError, got %v", err)
+	}
+
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(`<html>`))}, nil
+	})
+	if _, err := f.SendEmail(testRelayEmail()); err == nil {
+		t.Error("A bad response after a 2xx is not being returned.")
+	}
+
+	stub.mu.Lock()
+	if stub.data != nil {
+		t.Error("Emails the API accepted are falling back to SMTP.")
+	}
+	stub.mu.Unlock()
+
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 503, Body: ioutil.NopCloser(strings.NewReader(`{"code":"failure"}`))}, nil
+	})
+	client.archive = nil
+	if _, err := f.SendEmail(testRelayEmail()); err != nil {
+		t.Errorf("A 5xx is not falling back to SMTP: %v", err)
+	}
+}
+
+func TestFailoverSenderPreparesEmail(t *testing.T) {
+
+	stub := newSMTPStub(t)
+	archive := memArchive{}
+	client, _ := NewClient("123",
+		WithRecipientPolicy(&RecipientPolicy{RedirectEmail: "safe@example.com"}),
+		WithHTMLFilter(func(s string) (string, error) { return strings.ToUpper(s), nil }),
+		WithArchive(archive),
+	)
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	f := &FailoverSender{Primary: client, Fallback: stub.transport()}
+	resp, err := f.SendEmail(testRelayEmail())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if strings.Join(stub.rcpt, ",") != "safe@example.com" {
+		t.Errorf("The recipient policy is not being applied to the fallback: %v", stub.rcpt)
+	}
+	if !bytes.Contains(stub.data, []byte("<P>HI</P>")) {
+		t.Error("HTML filters are not being applied to the fallback.")
+	}
+	if _, ok := archive[resp.Data.Message_id]; !ok {
+		t.Error("Fallback sends are not being archived.")
+	}
+
+	sandboxed, _ := NewClient("123", WithSandbox(failingSink{}))
+	f.Primary = sandboxed
+	stub.rcpt = nil
+	stub.mu.Unlock()
+	_, err = f.SendEmail(testRelayEmail())
+	stub.mu.Lock()
+	if err == nil || stub.rcpt != nil {
+		t.Error("A sandboxed client is falling back to SMTP.")
+	}
+}
@@ -0,0 +1,207 @@
+package sib
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RenderedEmail is a template email with its placeholders substituted,
+// as the recipient would see it.
+type RenderedEmail struct {
+	To      string
+	Subject string
+	HTML    string
+	Missing []string // placeholders that had no value
+
+	From       [2]string // address and name
+	ReplyTo    string
+	Cc         string
+	Bcc        string
+	Attachment map[string]string
+	Headers    map[string]string
+}
+
+var defaultFilter = regexp.MustCompile(`^default\s*:\s*(?:"([^"]*)"|'([^']*)')$`)
+
+// RenderTemplate applies SendInBlue's substitution rules to a template
+// (as returned by GetTemplate) for the attributes of a TemplateEmail:
+//
+//   - %ATTR% is replaced verbatim by the attribute value and left in
+//     place when the attribute is missing.
+//   - {{ ATTR }}, {{ params.ATTR }} and {{ contact.ATTR }} are replaced by
+//     the HTML-escaped value, by the value of a `| default:"..."` filter,
+//     or by nothing when the attribute is missing.
+//   - %EMAIL% and {{ contact.EMAIL }} become the first recipient. Other
+//     built-in placeholders and {{{inline images}}} are left untouched.
+//
+// Attribute names are matched case-insensitively.
+func RenderTemplate(t *CampaignData, e *TemplateEmail) RenderedEmail {
+
+	attr := make(map[string]string)
+	to := ""
+	r := RenderedEmail{
+		From:    [2]string{t.From_email, t.From_name},
+		ReplyTo: t.Reply_to,
+	}
+	if e != nil {
+		for k, v := range e.Attr {
+			attr[strings.ToUpper(k)] = v
+		}
+		to = e.To
+		r.Cc, r.Bcc, r.Headers = e.Cc, e.Bcc, e.Headers
+		if e.ReplyTo != "" {
+			r.ReplyTo = e.ReplyTo
+		}
+		r.Attachment = copyMap(e.Attachment)
+		for _, u := range strings.Split(e.Attachment_url, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				if r.Attachment == nil {
+					r.Attachment = make(map[string]string)
+				}
+				r.Attachment[path.Base(u)] = u
+			}
+		}
+	}
+	if _, ok := attr["EMAIL"]; !ok && to != "" {
+		attr["EMAIL"] = strings.TrimSpace(strings.Split(to, "|")[0])
+	}
+
+	missing := make(map[string]bool)
+	r.To = to
+	r.Subject = substitute(t.Subject, attr, missing, false)
+	r.HTML = substitute(t.Html_content, attr, missing, true)
+	for _, name := range Placeholders(t.Subject + "\n" + t.Html_content) {
+		if missing[name] {
+			r.Missing = append(r.Missing, name)
+		}
+	}
+
+	return r
+}
+
+// PreviewTemplate fetches a template and renders it locally for e
+// without sending anything.
+func (c *Client) PreviewTemplate(id int, e *TemplateEmail) (RenderedEmail, error) {
+
+	resp, err := c.GetTemplate(id)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	if len(resp.Data) == 0 {
+		err := fmt.Errorf("Could not fetch template %d: %s", id, resp.Message)
+		return RenderedEmail{}, err
+	}
+
+	return RenderTemplate(&resp.Data[0], e), nil
+}
+
+// Email converts r into an Email, for example to write it as MIME.
+func (r *RenderedEmail) Email() *Email {
+
+	e := NewEmail()
+	e.Subject = r.Subject
+	e.HTML = r.HTML
+	e.From = r.From
+	e.ReplyTo = [2]string{r.ReplyTo, ""}
+	for _, list := range []struct {
+		s string
+		m map[string]string
+	}{{r.To, e.To}, {r.Cc, e.CC}, {r.Bcc, e.Bcc}} {
+		for _, addr := range strings.Split(list.s, "|") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				list.m[addr] = ""
+			}
+		}
+	}
+	for k, v := range r.Attachment {
+		e.Attachment[k] = v
+	}
+	for k, v := range r.Headers {
+		e.Headers[k] = v
+	}
+
+	return e
+}
+
+// WriteMIME writes the rendered email as a MIME message; see
+// Email.WriteMIME.
+func (r *RenderedEmail) WriteMIME(w io.Writer) error {
+	return r.Email().WriteMIME(w)
+}
+
+// substitute replaces both placeholder forms in one pass over s, so that
+// a value containing a placeholder is not substituted again.
+func substitute(s string, attr map[string]string, missing map[string]bool, escape bool) string {
+
+	type match struct {
+		loc     []int
+		percent bool
+	}
+	var matches []match
+	for _, m := range findPercentPlaceholders(s) {
+		matches = append(matches, match{m, true})
+	}
+	for _, m := range bracePlaceholder.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > 0 && s[m[0]-1] == '{' {
+			continue // {{{inline_image}}}
+		}
+		matches = append(matches, match{m, false})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].loc[0] < matches[j].loc[0] })
+
+	var b strings.Builder
+	last := 0
+	for _, mt := range matches {
+		m := mt.loc
+		if m[0] < last {
+			continue
+		}
+
+		v, ok := "", false
+		if mt.percent {
+			name := strings.ToUpper(s[m[2]:m[3]])
+			if v, ok = attr[name]; !ok {
+				if !BuiltinPlaceholders[name] {
+					missing[name] = true
+				}
+				continue
+			}
+		} else {
+			expr := s[m[2]:m[3]]
+			filter := ""
+			if i := strings.Index(expr, "|"); i >= 0 {
+				expr, filter = strings.TrimSpace(expr[:i]), strings.TrimSpace(expr[i+1:])
+			}
+			name := strings.TrimPrefix(strings.TrimPrefix(expr, "params."), "contact.")
+			if !placeholderName.MatchString(name) {
+				continue
+			}
+			name = strings.ToUpper(name)
+			if BuiltinPlaceholders[name] && name != "EMAIL" {
+				continue
+			}
+
+			if v, ok = attr[name]; !ok {
+				missing[name] = true
+				if f := defaultFilter.FindStringSubmatch(filter); f != nil {
+					v = f[1] + f[2]
+				}
+			}
+			if escape {
+				v = html.EscapeString(v)
+			}
+		}
+
+		b.WriteString(s[last:m[0]])
+		b.WriteString(v)
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+
+	return b.String()
+}
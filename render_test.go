@@ -0,0 +1,65 @@
+package sib
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+
+	tmpl := &CampaignData{
+		Subject:      "Welcome %FIRSTNAME%",
+		Html_content: `<p>Hi {{ params.FIRSTNAME }} {{ params.LASTNAME | default:"there" }},</p><p>%PLAN% {{ contact.EMAIL }}</p><img src="{{{logo.png}}}"><a href="%UNSUBSCRIBE%">x</a>`,
+	}
+	email := &TemplateEmail{
+		To:   "ada@example.net|bob@example.net",
+		Attr: map[string]string{"firstname": "Ada <3"},
+	}
+
+	r := RenderTemplate(tmpl, email)
+
+	if r.Subject != "Welcome Ada <3" {
+		t.Errorf("Subject is not being rendered: %q", r.Subject)
+	}
+
+	want := `<p>Hi Ada &lt;3 there,</p><p>%PLAN% ada@example.net</p><img src="{{{logo.png}}}"><a href="%UNSUBSCRIBE%">x</a>`
+	if r.HTML != want {
+		t.Errorf("HTML is not being rendered:\n got: %s\nwant: %s", r.HTML, want)
+	}
+
+	if !reflect.DeepEqual(r.Missing, []string{"LASTNAME", "PLAN"}) {
+		t.Errorf("Expected LASTNAME and PLAN to be reported missing, got %v", r.Missing)
+	}
+}
+
+func TestRenderTemplateSubstitutesOnce(t *testing.T) {
+
+	tmpl := &CampaignData{Html_content: `<p>%NOTE% {{ params.NAME }}</p>`}
+	email := &TemplateEmail{Attr: map[string]string{"NOTE": "{{ params.NAME }} & co", "NAME": "<Ada>"}}
+
+	r := RenderTemplate(tmpl, email)
+
+	if r.HTML != `<p>{{ params.NAME }} & co &lt;Ada&gt;</p>` {
+		t.Errorf("Attribute values are being substituted again: %s", r.HTML)
+	}
+}
+
+func TestPreviewTemplate(t *testing.T) {
+
+	client, _ := NewClient("123")
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != "GET" {
+			t.Errorf("PreviewTemplate must not send anything, got %s %s", r.Method, r.URL)
+		}
+		return jsonResponse(`{"code":"success","data":[{"id":7,"subject":"Hi %FIRSTNAME%","html_content":"<p>%FIRSTNAME%</p>"}]}`), nil
+	})
+
+	r, err := client.PreviewTemplate(7, &TemplateEmail{Attr: map[string]string{"FIRSTNAME": "Ada"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Subject != "Hi Ada" || r.HTML != "<p>Ada</p>" {
+		t.Errorf("Unexpected preview: %+v", r)
+	}
+}
@@ -0,0 +1,127 @@
+package sib
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Renderer renders emails from a set of Go templates, typically an
+// embed.FS. Files ending in .html are parsed with html/template, so
+// values are escaped for their HTML context; all other files are parsed
+// with text/template. Templates are referenced by their file name, and a
+// missing map key or field is an error.
+type Renderer struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// NewRenderer parses the files in fsys matching the glob patterns, "*"
+// by default. Parse errors are reported here, before anything is sent.
+func NewRenderer(fsys fs.FS, patterns ...string) (*Renderer, error) {
+
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+
+	var htmlFiles, textFiles []string
+	for _, p := range patterns {
+		matches, err := fs.Glob(fsys, p)
+		if err != nil {
+			err = fmt.Errorf("Could not match templates %s: %+v", p, err)
+			return nil, err
+		}
+		for _, m := range matches {
+			if path.Ext(m) == ".html" {
+				htmlFiles = append(htmlFiles, m)
+			} else {
+				textFiles = append(textFiles, m)
+			}
+		}
+	}
+	if len(htmlFiles)+len(textFiles) == 0 {
+		return nil, fmt.Errorf("No templates match %s", strings.Join(patterns, ", "))
+	}
+
+	r := &Renderer{
+		html: htmltemplate.New("").Option("missingkey=error"),
+		text: texttemplate.New("").Option("missingkey=error"),
+	}
+
+	var err error
+	if len(htmlFiles) > 0 {
+		r.html, err = r.html.ParseFS(fsys, htmlFiles...)
+		if err != nil {
+			err = fmt.Errorf("Could not parse HTML templates: %+v", err)
+			return nil, err
+		}
+	}
+	if len(textFiles) > 0 {
+		r.text, err = r.text.ParseFS(fsys, textFiles...)
+		if err != nil {
+			err = fmt.Errorf("Could not parse text templates: %+v", err)
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Render executes the templates for name with data and sets the result
+// on e. For name "welcome" these are:
+//
+//   - "welcome.html", an HTML template, for e.HTML
+//   - "welcome.txt", a text template, for e.Text
+//   - "welcome.subject", a text file or a {{define}} block in one, for e.Subject
+//
+// Each is optional but at least one body template must exist. e is only
+// modified when every template executes without error.
+func (r *Renderer) Render(e *Email, name string, data interface{}) error {
+
+	var subject, htmlBody, textBody bytes.Buffer
+
+	hasHTML := r.html.Lookup(name+".html") != nil
+	hasText := r.text.Lookup(name+".txt") != nil
+	hasSubject := r.text.Lookup(name+".subject") != nil
+	if !hasHTML && !hasText {
+		return fmt.Errorf("No template %s.html or %s.txt", name, name)
+	}
+
+	if hasHTML {
+		err := r.html.ExecuteTemplate(&htmlBody, name+".html", data)
+		if err != nil {
+			err = fmt.Errorf("Could not render %s.html: %+v", name, err)
+			return err
+		}
+	}
+	if hasText {
+		err := r.text.ExecuteTemplate(&textBody, name+".txt", data)
+		if err != nil {
+			err = fmt.Errorf("Could not render %s.txt: %+v", name, err)
+			return err
+		}
+	}
+	if hasSubject {
+		err := r.text.ExecuteTemplate(&subject, name+".subject", data)
+		if err != nil {
+			err = fmt.Errorf("Could not render %s.subject: %+v", name, err)
+			return err
+		}
+	}
+
+	if hasHTML {
+		e.HTML = htmlBody.String()
+	}
+	if hasText {
+		e.Text = textBody.String()
+	}
+	if hasSubject {
+		e.Subject = strings.Join(strings.Fields(subject.String()), " ")
+	}
+
+	return nil
+}
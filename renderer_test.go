@@ -0,0 +1,75 @@
+package sib
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type welcomeData struct {
+	Name string
+	Link string
+}
+
+func testRenderer(t *testing.T) *Renderer {
+
+	fsys := fstest.MapFS{
+		"welcome.html":    {Data: []byte(`<p>Hi {{.Name}}</p><a href="{{.Link}}">go</a>`)},
+		"welcome.txt":     {Data: []byte("Hi {{.Name}}, see {{.Link}}")},
+		"welcome.subject": {Data: []byte("Welcome,\n  {{.Name}}!\n")},
+		"broken.html":     {Data: []byte(`<p>{{.Missing}}</p>`)},
+	}
+
+	r, err := NewRenderer(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestRendererRender(t *testing.T) {
+
+	r := testRenderer(t)
+	e := NewEmail()
+
+	err := r.Render(e, "welcome", welcomeData{Name: "<Ada>", Link: "javascript:alert(1)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Subject != "Welcome, <Ada>!" {
+		t.Errorf("Subject is not being rendered: %q", e.Subject)
+	}
+	if !strings.Contains(e.HTML, "Hi &lt;Ada&gt;") || strings.Contains(e.HTML, "javascript:") {
+		t.Errorf("HTML is not being escaped: %q", e.HTML)
+	}
+	if e.Text != "Hi <Ada>, see javascript:alert(1)" {
+		t.Errorf("Text is not being rendered: %q", e.Text)
+	}
+}
+
+func TestRendererErrors(t *testing.T) {
+
+	r := testRenderer(t)
+	e := NewEmail()
+	e.HTML = "unchanged"
+
+	if err := r.Render(e, "broken", welcomeData{}); err == nil {
+		t.Error("Execution errors are not being reported.")
+	}
+	if err := r.Render(e, "welcome", map[string]string{"Name": "Ada"}); err == nil {
+		t.Error("Missing keys are not being reported.")
+	}
+	if err := r.Render(e, "nope", nil); err == nil {
+		t.Error("Unknown templates are not being reported.")
+	}
+	if e.HTML != "unchanged" {
+		t.Error("Email is being modified by a failed render.")
+	}
+
+	_, err := NewRenderer(fstest.MapFS{"bad.html": {Data: []byte("{{.Name")}})
+	if err == nil {
+		t.Error("Parse errors are not being reported.")
+	}
+}
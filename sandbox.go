@@ -0,0 +1,312 @@
+package sib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SandboxRequest is a request captured in sandbox mode instead of being sent.
+type SandboxRequest struct {
+	Time   time.Time       `json:"time"`
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"` // validation failure, if any
+}
+
+// A SandboxSink receives every request made in sandbox mode.
+type SandboxSink interface {
+	Record(r SandboxRequest) error
+}
+
+// WithSandbox puts the Client in sandbox mode: every request is built,
+// serialised and validated as usual, then handed to sink instead of
+// being sent to SendInBlue. Sends get synthetic, well-formed responses
+// (a fake message-id, SMS data with zero credits used); requests that
+// fail validation get a "failure" response, as the API would answer.
+// The sandbox sits in front of c.Client, so it stays in force when the
+// http.Client or its Transport is replaced later.
+func WithSandbox(sink SandboxSink) Option {
+	return func(c *Client) {
+		c.sandbox = &sandboxTransport{sink: sink}
+	}
+}
+
+// LogSink writes each sandboxed request to a logger.
+func LogSink(l *log.Logger) SandboxSink {
+	return logSink{l}
+}
+
+type logSink struct{ l *log.Logger }
+
+func (s logSink) Record(r SandboxRequest) error {
+	if r.Error != "" {
+		s.l.Printf("sandbox: %s %s rejected: %s: %s", r.Method, r.URL, r.Error, r.Body)
+		return nil
+	}
+	s.l.Printf("sandbox: %s %s %s", r.Method, r.URL, r.Body)
+	return nil
+}
+
+// JSONSink writes each sandboxed request to w as a line of JSON,
+// for instance to a file that tests inspect afterwards.
+func JSONSink(w io.Writer) SandboxSink {
+	return &jsonSink{enc: json.NewEncoder(w)}
+}
+
+type jsonSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (s *jsonSink) Record(r SandboxRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(r)
+}
+
+// ChanSink sends each sandboxed request on ch. The request blocks until
+// the channel accepts it.
+func ChanSink(ch chan<- SandboxRequest) SandboxSink {
+	return chanSink(ch)
+}
+
+type chanSink chan<- SandboxRequest
+
+func (s chanSink) Record(r SandboxRequest) error {
+	s <- r
+	return nil
+}
+
+type sandboxTransport struct {
+	ids  int64 // first for 64-bit alignment of atomic access
+	sink SandboxSink
+}
+
+func (t *sandboxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	if req.Header.Get("api-key") == "" {
+		return nil, fmt.Errorf("Sandbox: request has no api-key header")
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2.0/")
+	status, data, verr := t.handle(req.Method, path, body)
+
+	rec := SandboxRequest{
+		Time:   time.Now(),
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+	if len(body) > 0 {
+		if !json.Valid(body) {
+			return nil, fmt.Errorf("Sandbox: request body is not valid JSON")
+		}
+		rec.Body = body
+	}
+
+	resp := map[string]interface{}{"code": "success", "message": "Sandbox: not sent"}
+	if verr != nil {
+		rec.Error = verr.Error()
+		resp = map[string]interface{}{"code": "failure", "message": verr.Error()}
+	} else if data != nil {
+		resp["data"] = data
+	}
+
+	err := t.sink.Record(rec)
+	if err != nil {
+		return nil, fmt.Errorf("Sandbox: could not record request: %+v", err)
+	}
+
+	b, _ := json.Marshal(resp)
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
+
+// handle validates a request by endpoint and returns the status and the
+// "data" of the synthetic response.
+func (t *sandboxTransport) handle(method, path string, body []byte) (int, interface{}, error) {
+
+	var fields map[string]json.RawMessage
+	if len(body) > 0 {
+		json.Unmarshal(body, &fields)
+	}
+
+	switch {
+	case method == "POST" && path == "email":
+		var e Email
+		json.Unmarshal(body, &e)
+		return t.validate(validateEmail(&e), EmailData{Message_id: t.messageID()})
+
+	case method == "PUT" && strings.HasPrefix(path, "template/") && fields["template_name"] == nil:
+		var e TemplateEmail
+		json.Unmarshal(body, &e)
+		if strings.Trim(e.To, "| ") == "" {
+			return t.validate(fmt.Errorf("to is mandatory"), nil)
+		}
+		return t.validate(nil, EmailData{Message_id: t.messageID()})
+
+	case method == "PUT" && strings.HasPrefix(path, "template/"):
+		var tmpl Template
+		json.Unmarshal(body, &tmpl)
+		return t.validate(validateTemplate(&tmpl), nil)
+
+	case method == "POST" && path == "template":
+		var tmpl Template
+		json.Unmarshal(body, &tmpl)
+		return t.validate(validateTemplate(&tmpl), TemplateData{ID: t.nextID()})
+
+	case method == "POST" && path == "sms" && fields["name"] != nil:
+		var s SMSCampaign
+		json.Unmarshal(body, &s)
+		if s.Name == "" {
+			return t.validate(fmt.Errorf("name is mandatory"), nil)
+		}
+		return t.validate(nil, SMSCampaignData{Id: t.nextID()})
+
+	case method == "POST" && path == "sms":
+		var s SMSRequest
+		json.Unmarshal(body, &s)
+		return t.validate(validateSMS(&s), t.smsData(s.To))
+
+	case method == "GET" && strings.HasPrefix(path, "sms/"):
+		var s SMSTest
+		json.Unmarshal(body, &s)
+		if s.To == "" {
+			return t.validate(fmt.Errorf("to is mandatory"), nil)
+		}
+		return t.validate(nil, t.smsData(s.To))
+
+	case method == "GET" && strings.HasPrefix(path, "campaign/") && path != "campaign/detailsv2":
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "campaign/"), "/detailsv2"))
+		if err != nil {
+			return t.validate(fmt.Errorf("invalid campaign id"), nil)
+		}
+		return t.validate(nil, []CampaignData{stubTemplate(id)})
+
+	case method == "GET" && path == "campaign/detailsv2":
+		return t.validate(nil, TemplateListData{Campaign_records: []CampaignData{}, Page: 1})
+
+	case method == "POST" && path == "statistics":
+		return t.validate(nil, []AggregateData{})
+	}
+
+	return t.validate(nil, nil)
+}
+
+// stubTemplate is the template the sandbox returns for any id, so that
+// strict sends and archiving can run. It has no placeholders.
+func stubTemplate(id int) CampaignData {
+	name := fmt.Sprintf("Sandbox template %d", id)
+	return CampaignData{
+		ID:            id,
+		Campaign_name: name,
+		Subject:       name,
+		Type:          "template",
+		Html_content:  "<p>" + name + "</p>",
+		Templ_status:  "Active",
+		From_name:     "Sandbox",
+		From_email:    "sandbox@sendinblue.invalid",
+	}
+}
+
+func (t *sandboxTransport) validate(err error, data interface{}) (int, interface{}, error) {
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	return http.StatusOK, data, nil
+}
+
+func (t *sandboxTransport) nextID() int {
+	return int(atomic.AddInt64(&t.ids, 1))
+}
+
+func (t *sandboxTransport) messageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@sandbox.sendinblue.invalid>", hex.EncodeToString(b))
+}
+
+func (t *sandboxTransport) smsData(to string) SMSData {
+	return SMSData{
+		Status:      "OK",
+		Number_sent: 1,
+		To:          to,
+		Sms_count:   1,
+		Reference:   SMSReference{One: fmt.Sprintf("sandbox-%d", t.nextID())},
+	}
+}
+
+func validateEmail(e *Email) error {
+	switch {
+	case len(e.To) == 0:
+		return fmt.Errorf("to is mandatory")
+	case e.From[0] == "":
+		return fmt.Errorf("from is mandatory")
+	case e.Subject == "":
+		return fmt.Errorf("subject is mandatory")
+	case e.HTML == "" && e.Text == "":
+		return fmt.Errorf("html or text is mandatory")
+	}
+	return nil
+}
+
+func validateTemplate(t *Template) error {
+	switch {
+	case t.Template_name == "":
+		return fmt.Errorf("template_name is mandatory")
+	case t.Html_content == "" && t.Html_url == "":
+		return fmt.Errorf("html_content or html_url is mandatory")
+	case t.Subject == "":
+		return fmt.Errorf("subject is mandatory")
+	case t.From_email == "":
+		return fmt.Errorf("from_email is mandatory")
+	}
+	return nil
+}
+
+func validateSMS(s *SMSRequest) error {
+	switch {
+	case s.To == "":
+		return fmt.Errorf("to is mandatory")
+	case s.From == "":
+		return fmt.Errorf("from is mandatory")
+	case len(s.From) > 11:
+		return fmt.Errorf("from must not exceed 11 characters")
+	case s.Text == "":
+		return fmt.Errorf("text is mandatory")
+	case len(s.Text) > 160:
+		return fmt.Errorf("text must not exceed 160 characters")
+	}
+	return nil
+}
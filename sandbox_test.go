@@ -0,0 +1,131 @@
+package sib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSandbox(t *testing.T) {
+
+	requests := make(chan SandboxRequest, 10)
+	client, _ := NewClient("123", WithSandbox(ChanSink(requests)))
+
+	email := NewEmail()
+	email.From = [2]string{"sender@example.net", "Sender"}
+	email.To["ada@example.net"] = "Ada"
+	email.Subject = "Hello"
+	email.Text = "Hello World."
+
+	resp, err := client.SendEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != "success" || !strings.HasPrefix(resp.Data.Message_id, "<") {
+		t.Errorf("Expected a synthetic message-id, got %+v", resp)
+	}
+
+	rec := <-requests
+	if rec.Method != "POST" || !strings.HasSuffix(rec.URL, "/v2.0/email") {
+		t.Errorf("Unexpected recorded request: %s %s", rec.Method, rec.URL)
+	}
+	var sent Email
+	json.Unmarshal(rec.Body, &sent)
+	if sent.To["ada@example.net"] != "Ada" {
+		t.Errorf("Recorded body does not match the email: %s", rec.Body)
+	}
+
+	email.Subject = ""
+	resp, err = client.SendEmail(email)
+	if err != nil || resp.Code != "failure" || !strings.Contains(resp.Message, "subject") {
+		t.Errorf("Expected a validation failure, got %+v %v", resp, err)
+	}
+	if rec := <-requests; rec.Error == "" {
+		t.Error("Validation failure is not being recorded.")
+	}
+
+	sms, err := client.SendSMS(&SMSRequest{To: "+3100000001", From: "Shop", Text: "Hi"})
+	if err != nil || sms.Code != "success" || sms.Data.Credits_used != 0 || sms.Data.To != "+3100000001" {
+		t.Errorf("Unexpected SMS response: %+v %v", sms, err)
+	}
+	<-requests
+
+	campaign, _ := client.CreateSMSCampaign(&SMSCampaign{Name: "Spring"})
+	if campaign.Data.Id == 0 {
+		t.Errorf("Expected a synthetic campaign ID, got %+v", campaign)
+	}
+	<-requests
+
+	if err := client.UpdateTemplate(3, &Template{Template_name: "x"}); err == nil {
+		t.Error("Expected UpdateTemplate to fail validation.")
+	}
+	<-requests
+
+	template, _ := client.SendTemplateEmail(3, []string{"ada@example.net"}, nil)
+	if template.Data.Message_id == "" {
+		t.Errorf("Expected a synthetic message-id for a template email, got %+v", template)
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+
+	var buf bytes.Buffer
+	client, _ := NewClient("123", WithSandbox(JSONSink(&buf)))
+
+	client.DeleteBouncedEmails("2017-01-01", "2017-01-31", "")
+	client.AggregateReport(&AggregateReport{Days: 7})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 recorded requests, got:\n%s", buf.String())
+	}
+	var rec SandboxRequest
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil || !strings.HasSuffix(rec.URL, "/statistics") {
+		t.Errorf("Unexpected recorded request %s: %v", lines[1], err)
+	}
+}
+
+func TestSandboxSurvivesTransportChange(t *testing.T) {
+
+	requests := make(chan SandboxRequest, 10)
+	client, _ := NewClient("123", WithSandbox(ChanSink(requests)))
+	client.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("Sandboxed request reached the transport: %s %s", r.Method, r.URL)
+		return jsonResponse(`{"code":"success"}`), nil
+	})
+
+	email := NewEmail()
+	email.From = [2]string{"sender@example.net", ""}
+	email.To["ada@example.net"] = ""
+	email.Subject = "Hello"
+	email.Text = "Hi"
+	client.SendEmail(email)
+
+	if len(requests) != 1 {
+		t.Error("Request is not being recorded by the sandbox.")
+	}
+}
+
+func TestSandboxTemplates(t *testing.T) {
+
+	archive := memArchive{}
+	client, _ := NewClient("123", WithSandbox(LogSink(log.New(ioutil.Discard, "", 0))),
+		WithStrictTemplates(nil), WithArchive(archive))
+
+	tmpl, err := client.GetTemplate(7)
+	if err != nil || len(tmpl.Data) != 1 || tmpl.Data[0].ID != 7 {
+		t.Errorf("The sandbox is not returning a template: %+v %v", tmpl, err)
+	}
+
+	resp, err := client.SendTemplateEmail(7, []string{"ada@example.com"}, nil)
+	if err != nil || resp.Code != "success" {
+		t.Fatalf("Strict template sends are failing in the sandbox: %+v %v", resp, err)
+	}
+	if _, ok := archive[resp.Data.Message_id]; !ok {
+		t.Error("Template sends are not being archived in the sandbox.")
+	}
+}
@@ -0,0 +1,75 @@
+package sibtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// RecordedRequest is the recorded side of an API request.
+type RecordedRequest struct {
+	Method string              `json:"method"`
+	URL    string              `json:"url"`
+	Header map[string][]string `json:"header,omitempty"`
+	Body   string              `json:"body,omitempty"`
+}
+
+// RecordedResponse is the recorded answer to a RecordedRequest.
+type RecordedResponse struct {
+	Status int                 `json:"status"`
+	Header map[string][]string `json:"header,omitempty"`
+	Body   string              `json:"body"`
+}
+
+// Interaction is one request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Cassette is a file of recorded interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette file.
+func LoadCassette(path string) (*Cassette, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("Could not read cassette: %+v", err)
+		return nil, err
+	}
+
+	c := &Cassette{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		err = fmt.Errorf("Could not decode cassette %s: %+v", path, err)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Save writes the cassette to path, creating its directory if needed.
+func (c *Cassette) Save(path string) error {
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("Could not marshal JSON: %+v", err)
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, append(b, '\n'), 0644)
+	}
+	if err != nil {
+		err = fmt.Errorf("Could not write cassette: %+v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+// Package sibtest records SendInBlue API interactions to cassette files
+// and replays them, so integration tests can run offline.
+//
+//	rec, err := sibtest.NewRecorder("testdata/send_email.json", sibtest.ModeFromEnv())
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer rec.Stop()
+//
+//	key := os.Getenv("SIB_KEY")
+//	if key == "" {
+//		key = "replay" // any key will do when replaying
+//	}
+//	client, _ := sib.NewClient(key)
+//	client.Client.Transport = rec
+//
+// Run the tests once with SIB_RECORD=1 and a real SIB_KEY to record the
+// cassettes; afterwards they replay without network access. The api-key
+// header is never written to a cassette.
+package sibtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Mode selects whether a Recorder talks to the API or replays a cassette.
+type Mode int
+
+const (
+	Replay Mode = iota
+	Record
+)
+
+// Redacted replaces the value of sensitive headers in cassettes.
+const Redacted = "REDACTED"
+
+// SensitiveHeaders are redacted before an interaction is recorded.
+var SensitiveHeaders = []string{"Api-Key", "Authorization"}
+
+// ModeFromEnv returns Record when the SIB_RECORD environment variable is
+// set to a non-empty value other than 0, and Replay otherwise.
+func ModeFromEnv() Mode {
+	if v := os.Getenv("SIB_RECORD"); v != "" && v != "0" {
+		return Record
+	}
+	return Replay
+}
+
+// Recorder is an http.RoundTripper for sib.Client.Client that records
+// interactions to a cassette or replays them from one.
+type Recorder struct {
+	path string
+	mode Mode
+
+	// Transport makes the real requests in Record mode;
+	// http.DefaultTransport when nil.
+	Transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewRecorder returns a Recorder for the cassette at path. In Replay mode
+// the cassette must exist; in Record mode it is written by Stop.
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+
+	r := &Recorder{path: path, mode: mode, cassette: &Cassette{}}
+
+	if mode == Replay {
+		c, err := LoadCassette(path)
+		if err != nil {
+			return nil, err
+		}
+		r.cassette = c
+		r.used = make([]bool, len(c.Interactions))
+	}
+
+	return r, nil
+}
+
+// Stop saves the cassette in Record mode.
+func (r *Recorder) Stop() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode != Record {
+		return nil
+	}
+	return r.cassette.Save(r.path)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	if r.mode == Record {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+
+	t := r.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+
+	resp, err := t.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: redact(req.Header),
+			Body:   string(body),
+		},
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: redact(resp.Header),
+			Body:   string(respBody),
+		},
+	})
+	r.mu.Unlock()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// replay answers with the first unused interaction matching the method,
+// path and normalised JSON body, or else with a used one.
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+
+	want := normalizeBody(body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := -1
+	for i, in := range r.cassette.Interactions {
+		if !r.matches(in.Request, req, want) {
+			continue
+		}
+		if !r.used[i] {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("sibtest: no interaction in %s matches %s %s %s", r.path, req.Method, req.URL.Path, body)
+	}
+	r.used[match] = true
+
+	rec := r.cassette.Interactions[match].Response
+	return &http.Response{
+		StatusCode:    rec.Status,
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(rec.Header),
+		Body:          ioutil.NopCloser(strings.NewReader(rec.Body)),
+		ContentLength: int64(len(rec.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Recorder) matches(rec RecordedRequest, req *http.Request, body string) bool {
+
+	if rec.Method != req.Method {
+		return false
+	}
+
+	u, err := req.URL.Parse(rec.URL)
+	if err != nil || u.Path != req.URL.Path {
+		return false
+	}
+
+	return normalizeBody([]byte(rec.Body)) == body
+}
+
+// normalizeBody re-encodes JSON so that key order and whitespace do not
+// affect matching. Other bodies are compared as they are.
+func normalizeBody(b []byte) string {
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		return ""
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return string(b)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return string(b)
+	}
+	return string(out)
+}
+
+func redact(h http.Header) map[string][]string {
+
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		out[k] = v
+	}
+	for _, name := range SensitiveHeaders {
+		key := http.CanonicalHeaderKey(name)
+		if _, ok := out[key]; ok {
+			out[key] = []string{Redacted}
+		}
+	}
+
+	return out
+}
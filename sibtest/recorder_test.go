@@ -0,0 +1,99 @@
+package sibtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "sibtest")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassettes", "sms.json")
+
+	rec, err := NewRecorder(path, Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	rec.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		b, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(b), `"to":"+3100000001"`) {
+			t.Errorf("Request body is not being passed on: %s", b)
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"code":"success","data":{"status":"OK","reference":{"1":"abc"}}}`)),
+		}, nil
+	})
+
+	client, _ := sib.NewClient("secret-key")
+	client.Client.Transport = rec
+
+	sms := &sib.SMSRequest{To: "+3100000001", From: "Shop", Text: "Hi"}
+	resp, err := client.SendSMS(sms)
+	if err != nil || resp.Data.Reference.One != "abc" || calls != 1 {
+		t.Fatalf("Unexpected recorded response: %+v %v", resp, err)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "secret-key") || !strings.Contains(string(b), Redacted) {
+		t.Errorf("api-key header is not being redacted:\n%s", b)
+	}
+
+	rec, err = NewRecorder(path, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ = sib.NewClient("other-key")
+	client.Client.Transport = rec
+
+	resp, err = client.SendSMS(sms)
+	if err != nil || resp.Data.Reference.One != "abc" {
+		t.Errorf("Unexpected replayed response: %+v %v", resp, err)
+	}
+	if calls != 1 {
+		t.Error("Replay is making real requests.")
+	}
+
+	sms.Text = "Something else"
+	if _, err := client.SendSMS(sms); err == nil {
+		t.Error("Expected a request with a different body not to match.")
+	}
+}
+
+func TestNormalizeBody(t *testing.T) {
+
+	a := normalizeBody([]byte(`{"b": 1, "a": {"y": 2, "x": 1}}`))
+	b := normalizeBody([]byte(`{"a":{"x":1,"y":2},"b":1}`))
+	if a != b {
+		t.Errorf("JSON bodies are not being normalised: %s != %s", a, b)
+	}
+
+	if normalizeBody([]byte("not json")) != "not json" {
+		t.Error("Non-JSON bodies should be compared as they are.")
+	}
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	if _, err := NewRecorder(filepath.Join(os.TempDir(), "sibtest-missing.json"), Replay); err == nil {
+		t.Error("Expected replaying a missing cassette to fail.")
+	}
+}
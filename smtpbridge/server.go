@@ -0,0 +1,658 @@
+// Package smtpbridge accepts mail over SMTP and forwards it through the
+// SendInBlue API, so that applications which only speak SMTP can use it.
+package smtpbridge
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JKhawaja/sendinblue"
+	"github.com/JKhawaja/sendinblue/internal/ratelimit"
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("Server closed")
+
+var errLineTooLong = errors.New("Line too long")
+
+const (
+	// maxLineLength caps command and message lines. It is above the 1000
+	// octets of RFC 5321 to tolerate sloppy clients and long AUTH lines.
+	maxLineLength = 4096
+
+	// maxLimiters caps the number of clients whose rate is tracked.
+	maxLimiters = 10000
+
+	// maxRecipients caps the RCPT commands per message, the minimum a
+	// server must accept under RFC 5321 4.5.3.1.8.
+	maxRecipients = 100
+)
+
+// Server is an SMTP server that parses each accepted message into a
+// sib.Email and sends it through Sender.
+type Server struct {
+	Addr     string // defaults to localhost:2525
+	Hostname string // used in the greeting, defaults to localhost
+
+	Sender sib.EmailSender
+
+	// Auth, when set, is required before MAIL. Credentials are only
+	// accepted over TLS unless AllowInsecureAuth is set, for instance for
+	// a server that only listens on localhost.
+	Auth              func(username, password string) bool
+	AllowInsecureAuth bool
+
+	// AllowSenders lists the addresses ("app@example.com") or domains
+	// ("@example.com") that may send. An empty list allows any sender.
+	AllowSenders []string
+
+	// Rate limits each client, identified by username or remote IP, to
+	// Rate messages per second with bursts of Burst. Zero disables it.
+	Rate  float64
+	Burst int
+
+	MaxSize     int64         // message size limit, defaults to 10MB
+	TLSConfig   *tls.Config   // enables STARTTLS
+	ReadTimeout time.Duration // per command, defaults to 5 minutes
+	ErrorLog    *log.Logger   // defaults to the log package
+
+	mu       sync.Mutex
+	ln       net.Listener
+	conns    map[net.Conn]struct{}
+	limiters map[string]*ratelimit.Limiter
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// ListenAndServe listens on s.Addr and serves until Close.
+func (s *Server) ListenAndServe() error {
+
+	addr := s.Addr
+	if addr == "" {
+		addr = "localhost:2525"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close.
+func (s *Server) Serve(ln net.Listener) error {
+
+	if s.Auth != nil && s.TLSConfig == nil && !s.AllowInsecureAuth {
+		ln.Close()
+		return fmt.Errorf("Auth requires TLSConfig or AllowInsecureAuth")
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the listener, closes open connections and waits for
+// their handlers to return.
+func (s *Server) Close() error {
+
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (s *Server) allowSender(addr string) bool {
+
+	if len(s.AllowSenders) == 0 {
+		return true
+	}
+
+	addr = strings.ToLower(addr)
+	for _, a := range s.AllowSenders {
+		a = strings.ToLower(a)
+		if a == addr || (strings.HasPrefix(a, "@") && strings.HasSuffix(addr, a)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allow takes a token from the client's bucket.
+func (s *Server) allow(client string) bool {
+
+	if s.Rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	if s.limiters == nil {
+		s.limiters = make(map[string]*ratelimit.Limiter)
+	}
+	l, ok := s.limiters[client]
+	if !ok {
+		if len(s.limiters) >= maxLimiters {
+			s.evictLimiters(now)
+		}
+		l = ratelimit.New(s.Rate, s.Burst)
+		s.limiters[client] = l
+	}
+	s.mu.Unlock()
+
+	return l.Allow(now)
+}
+
+// evictLimiters drops the buckets that have refilled, which are the same
+// as new ones, or an arbitrary one when none has. s.mu must be held.
+func (s *Server) evictLimiters(now time.Time) {
+
+	for client, l := range s.limiters {
+		if l.Full(now) {
+			delete(s.limiters, client)
+		}
+	}
+
+	for client := range s.limiters {
+		if len(s.limiters) < maxLimiters {
+			break
+		}
+		delete(s.limiters, client)
+	}
+}
+
+// session is the state of one SMTP connection.
+type session struct {
+	s      *Server
+	conn   net.Conn
+	tp     *textproto.Conn
+	secure bool
+	helo   bool
+	user   string
+	from   string
+	rcpt   []string
+}
+
+func (s *Server) serve(conn net.Conn) {
+
+	ss := &session{s: s}
+	ss.setConn(conn)
+	defer ss.tp.Close()
+
+	ss.reply(220, "%s ESMTP sib-smtp-bridge", s.hostname())
+	for {
+		timeout := s.ReadTimeout
+		if timeout == 0 {
+			timeout = 5 * time.Minute
+		}
+		ss.conn.SetReadDeadline(time.Now().Add(timeout))
+
+		line, err := ss.tp.ReadLine()
+		if err == errLineTooLong {
+			ss.reply(500, "5.5.2 Line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		if !ss.handle(strings.ToUpper(verb), arg) {
+			return
+		}
+	}
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname == "" {
+		return "localhost"
+	}
+	return s.Hostname
+}
+
+func (s *Server) maxSize() int64 {
+	if s.MaxSize <= 0 {
+		return 10 << 20
+	}
+	return s.MaxSize
+}
+
+// setConn reads and writes the session through conn, with line lengths
+// capped at maxLineLength.
+func (ss *session) setConn(conn net.Conn) {
+	ss.conn = conn
+	ss.tp = textproto.NewConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{&lineLimiter{r: conn}, conn, conn})
+}
+
+func (ss *session) reply(code int, format string, args ...interface{}) {
+	ss.tp.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (ss *session) reset() {
+	ss.from, ss.rcpt = "", nil
+}
+
+// handle runs one command, reporting false when the connection should
+// be closed.
+func (ss *session) handle(verb, arg string) bool {
+
+	s := ss.s
+
+	switch verb {
+	case "HELO":
+		ss.helo = true
+		ss.reset()
+		ss.reply(250, "%s", s.hostname())
+
+	case "EHLO":
+		ss.helo = true
+		ss.reset()
+		ext := []string{s.hostname(), fmt.Sprintf("SIZE %d", s.maxSize()), "8BITMIME"}
+		if s.TLSConfig != nil && !ss.secure {
+			ext = append(ext, "STARTTLS")
+		}
+		if ss.authAllowed() {
+			ext = append(ext, "AUTH PLAIN LOGIN")
+		}
+		for _, e := range ext[:len(ext)-1] {
+			ss.tp.PrintfLine("250-%s", e)
+		}
+		ss.reply(250, "%s", ext[len(ext)-1])
+
+	case "STARTTLS":
+		if s.TLSConfig == nil || ss.secure {
+			ss.reply(502, "5.5.1 STARTTLS not available")
+			break
+		}
+		ss.reply(220, "2.0.0 Ready to start TLS")
+		tc := tls.Server(ss.conn, s.TLSConfig)
+		if err := tc.Handshake(); err != nil {
+			return false
+		}
+		ss.setConn(tc)
+		ss.secure = true
+		ss.helo, ss.user = false, ""
+		ss.reset()
+
+	case "AUTH":
+		ss.auth(arg)
+
+	case "MAIL":
+		ss.mail(arg)
+
+	case "RCPT":
+		if ss.from == "" {
+			ss.reply(503, "5.5.1 MAIL first")
+			break
+		}
+		addr, ok := parsePath(arg, "TO:")
+		if !ok || addr == "" {
+			ss.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+			break
+		}
+		if len(ss.rcpt) >= maxRecipients {
+			ss.reply(452, "4.5.3 Too many recipients")
+			break
+		}
+		ss.rcpt = append(ss.rcpt, addr)
+		ss.reply(250, "2.1.5 OK")
+
+	case "DATA":
+		if len(ss.rcpt) == 0 {
+			ss.reply(503, "5.5.1 RCPT first")
+			break
+		}
+		return ss.data()
+
+	case "RSET":
+		ss.reset()
+		ss.reply(250, "2.0.0 OK")
+
+	case "NOOP":
+		ss.reply(250, "2.0.0 OK")
+
+	case "VRFY":
+		ss.reply(252, "2.5.0 Cannot verify")
+
+	case "QUIT":
+		ss.reply(221, "2.0.0 Bye")
+		return false
+
+	default:
+		ss.reply(502, "5.5.2 Command not recognized")
+	}
+
+	return true
+}
+
+func (ss *session) authAllowed() bool {
+	return ss.s.Auth != nil && ss.user == "" && (ss.secure || ss.s.AllowInsecureAuth)
+}
+
+func (ss *session) auth(arg string) {
+
+	if !ss.helo {
+		ss.reply(503, "5.5.1 EHLO first")
+		return
+	}
+	if !ss.authAllowed() {
+		ss.reply(503, "5.5.1 AUTH not available")
+		return
+	}
+
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		ss.reply(501, "5.5.4 Syntax: AUTH mechanism")
+		return
+	}
+
+	var user, pass string
+	switch strings.ToUpper(fields[0]) {
+	case "PLAIN":
+		resp := ""
+		if len(fields) > 1 {
+			resp = fields[1]
+		} else {
+			resp = ss.challenge("")
+		}
+		b, err := base64.StdEncoding.DecodeString(resp)
+		parts := strings.Split(string(b), "\x00")
+		if err != nil || len(parts) != 3 {
+			ss.reply(501, "5.5.2 Invalid credentials encoding")
+			return
+		}
+		user, pass = parts[1], parts[2]
+
+	case "LOGIN":
+		u, err := base64.StdEncoding.DecodeString(ss.challenge("Username:"))
+		if err != nil {
+			ss.reply(501, "5.5.2 Invalid credentials encoding")
+			return
+		}
+		p, err := base64.StdEncoding.DecodeString(ss.challenge("Password:"))
+		if err != nil {
+			ss.reply(501, "5.5.2 Invalid credentials encoding")
+			return
+		}
+		user, pass = string(u), string(p)
+
+	default:
+		ss.reply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+
+	if !ss.s.Auth(user, pass) {
+		ss.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	ss.user = user
+	ss.reply(235, "2.7.0 Authentication successful")
+}
+
+// challenge sends a 334 prompt and returns the client's response.
+func (ss *session) challenge(prompt string) string {
+	ss.reply(334, "%s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, _ := ss.tp.ReadLine()
+	return strings.TrimSpace(line)
+}
+
+func (ss *session) mail(arg string) {
+
+	s := ss.s
+
+	switch {
+	case !ss.helo:
+		ss.reply(503, "5.5.1 EHLO first")
+		return
+	case ss.from != "":
+		ss.reply(503, "5.5.1 Sender already given")
+		return
+	case s.Auth != nil && ss.user == "":
+		ss.reply(530, "5.7.0 Authentication required")
+		return
+	}
+
+	addr, ok := parsePath(arg, "FROM:")
+	if !ok || addr == "" {
+		ss.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	if !s.allowSender(addr) {
+		ss.reply(550, "5.7.1 Sender %s not allowed", addr)
+		return
+	}
+
+	client := ss.user
+	if client == "" {
+		client, _, _ = net.SplitHostPort(ss.conn.RemoteAddr().String())
+	}
+	if !s.allow(client) {
+		ss.reply(451, "4.7.0 Rate limit exceeded, try again later")
+		return
+	}
+
+	ss.from = addr
+	ss.reply(250, "2.1.0 OK")
+}
+
+// data reads and forwards a message, reporting false when the connection
+// should be closed.
+func (ss *session) data() bool {
+
+	s := ss.s
+	defer ss.reset()
+
+	ss.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	var buf bytes.Buffer
+	r := ss.tp.DotReader()
+	n, err := io.Copy(&buf, io.LimitReader(r, s.maxSize()+1))
+	if err == errLineTooLong {
+		ss.reply(500, "5.5.2 Line too long")
+		return false
+	}
+	if err != nil {
+		return false
+	}
+	if n > s.maxSize() {
+		io.Copy(ioutil.Discard, r)
+		ss.reply(552, "5.3.4 Message too big")
+		return true
+	}
+
+	e, err := sib.ReadEmail(&buf)
+	if err != nil {
+		ss.reply(554, "5.6.0 %v", err)
+		return true
+	}
+	setRecipients(e, ss.rcpt)
+	if !s.allowSender(e.From[0]) {
+		ss.reply(550, "5.7.1 Sender %s not allowed", e.From[0])
+		return true
+	}
+
+	resp, err := s.Sender.SendEmail(e)
+	if err != nil {
+		s.logf("smtpbridge: could not forward message from %s: %v", ss.from, err)
+		ss.reply(451, "4.3.0 Could not forward message, try again later")
+		return true
+	}
+	if resp.Code != "success" {
+		ss.reply(554, "5.0.0 Rejected: %s", resp.Message)
+		return true
+	}
+
+	ss.reply(250, "2.0.0 OK %s", resp.Data.Message_id)
+	return true
+}
+
+// setRecipients delivers e to the envelope recipients only. Those named in
+// the To or Cc header keep their place and display name, the others are
+// sent as Bcc; header addresses that were not given in RCPT are dropped.
+func setRecipients(e *sib.Email, rcpt []string) {
+
+	find := func(m map[string]string, addr string) (string, bool) {
+		for a, name := range m {
+			if strings.EqualFold(a, addr) {
+				return name, true
+			}
+		}
+		return "", false
+	}
+
+	to, cc, bcc := make(map[string]string), make(map[string]string), make(map[string]string)
+	seen := make(map[string]bool)
+	for _, addr := range rcpt {
+		key := strings.ToLower(addr)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if name, ok := find(e.To, addr); ok {
+			to[addr] = name
+		} else if name, ok := find(e.CC, addr); ok {
+			cc[addr] = name
+		} else {
+			bcc[addr] = ""
+		}
+	}
+
+	e.To, e.CC, e.Bcc = to, cc, bcc
+}
+
+// parsePath extracts the address from "FROM:<addr> PARAMS".
+func parsePath(arg, prefix string) (string, bool) {
+
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+
+	if !strings.HasPrefix(arg, "<") {
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			return "", false
+		}
+		return fields[0], true
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", false
+	}
+
+	return arg[1:end], true
+}
+
+// lineLimiter fails reads once a line runs past maxLineLength. The error
+// sticks, since the rest of the line cannot be read as a command.
+type lineLimiter struct {
+	r   io.Reader
+	n   int // bytes since the last newline
+	err error
+}
+
+func (l *lineLimiter) Read(p []byte) (int, error) {
+
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	n, err := l.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.n = 0
+			continue
+		}
+		l.n++
+		if l.n > maxLineLength {
+			l.err = errLineTooLong
+			return i, l.err
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,291 @@
+package smtpbridge
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type fakeSender struct {
+	mu     sync.Mutex
+	emails []*sib.Email
+	err    error
+}
+
+func (f *fakeSender) SendEmail(e *sib.Email) (sib.EmailResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return sib.EmailResponse{}, f.err
+	}
+	f.emails = append(f.emails, e)
+	var resp sib.EmailResponse
+	resp.Code = "success"
+	resp.Data.Message_id = "<1@smtp-relay.mailin.fr>"
+	return resp, nil
+}
+
+func startServer(t *testing.T, s *Server) string {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+
+	return ln.Addr().String()
+}
+
+const testMessage = "From: App <app@example.com>\r\n" +
+	"To: Jane <jane@example.org>\r\n" +
+	"Subject: =?utf-8?q?Caf=C3=A9?=\r\n" +
+	"X-Mailer: legacy\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=mixed\r\n" +
+	"\r\n" +
+	"--mixed\r\n" +
+	"Content-Type: multipart/related; boundary=related\r\n" +
+	"\r\n" +
+	"--related\r\n" +
+	"Content-Type: multipart/alternative; boundary=alt\r\n" +
+	"\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=C3=A9 is open\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Open</p><img src=\"cid:logo@app\">\r\n" +
+	"--alt--\r\n" +
+	"--related\r\n" +
+	"Content-Type: image/png; name=logo.png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-ID: <logo@app>\r\n" +
+	"\r\n" +
+	"iVBORw0K\r\n" +
+	"--related--\r\n" +
+	"--mixed\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Disposition: attachment; filename=\"menu.txt\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"c291cA==\r\n" +
+	"--mixed--\r\n"
+
+func TestServerForwardsMessage(t *testing.T) {
+
+	sender := &fakeSender{}
+	addr := startServer(t, &Server{Sender: sender})
+
+	err := smtp.SendMail(addr, nil, "app@example.com", []string{"jane@example.org", "audit@example.com"}, []byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.emails) != 1 {
+		t.Fatalf("Message is not being forwarded: %d sent", len(sender.emails))
+	}
+	e := sender.emails[0]
+
+	if e.From != [2]string{"app@example.com", "App"} {
+		t.Errorf("From is not being parsed: %v", e.From)
+	}
+	if e.To["jane@example.org"] != "Jane" {
+		t.Errorf("To is not being parsed: %v", e.To)
+	}
+	if _, ok := e.Bcc["audit@example.com"]; !ok {
+		t.Errorf("Envelope-only recipients are not being sent as Bcc: %v", e.Bcc)
+	}
+	if len(e.To)+len(e.CC)+len(e.Bcc) != 2 {
+		t.Errorf("Unexpected recipients: %v %v %v", e.To, e.CC, e.Bcc)
+	}
+	if e.Subject != "Café" {
+		t.Errorf("Subject is not being decoded: %q", e.Subject)
+	}
+	if e.Text != "Café is open" {
+		t.Errorf("Quoted-printable text is not being decoded: %q", e.Text)
+	}
+	if e.HTML != `<p>Open</p><img src="{{{logo.png}}}">` {
+		t.Errorf("CID references are not being rewritten: %q", e.HTML)
+	}
+	if e.Inline_image["logo.png"] != "iVBORw0K" {
+		t.Errorf("Inline images are not being kept: %v", e.Inline_image)
+	}
+	if b, _ := base64.StdEncoding.DecodeString(e.Attachment["menu.txt"]); string(b) != "soup" {
+		t.Errorf("Attachments are not being kept: %v", e.Attachment)
+	}
+	if e.Headers["X-Mailer"] != "legacy" {
+		t.Errorf("X- headers are not being kept: %v", e.Headers)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+
+	sender := &fakeSender{}
+	addr := startServer(t, &Server{
+		Sender:            sender,
+		Auth:              func(u, p string) bool { return u == "app" && p == "secret" },
+		AllowInsecureAuth: true,
+	})
+
+	err := smtp.SendMail(addr, nil, "app@example.com", []string{"jane@example.org"}, []byte(testMessage))
+	if err == nil || !strings.HasPrefix(err.Error(), "530") {
+		t.Errorf("Unauthenticated senders are not being rejected: %v", err)
+	}
+
+	bad := smtp.PlainAuth("", "app", "wrong", "127.0.0.1")
+	err = smtp.SendMail(addr, bad, "app@example.com", []string{"jane@example.org"}, []byte(testMessage))
+	if err == nil || !strings.HasPrefix(err.Error(), "535") {
+		t.Errorf("Invalid credentials are not being rejected: %v", err)
+	}
+
+	good := smtp.PlainAuth("", "app", "secret", "127.0.0.1")
+	err = smtp.SendMail(addr, good, "app@example.com", []string{"jane@example.org"}, []byte(testMessage))
+	if err != nil {
+		t.Errorf("Valid credentials are not being accepted: %v", err)
+	}
+}
+
+func TestServerRequiresTLSForAuth(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Sender: &fakeSender{}, Auth: func(u, p string) bool { return true }}
+	if err := s.Serve(ln); err == nil || err == ErrServerClosed {
+		t.Errorf("Auth without TLS is not being refused: %v", err)
+	}
+}
+
+func TestServerDeliversToEnvelopeOnly(t *testing.T) {
+
+	sender := &fakeSender{}
+	addr := startServer(t, &Server{Sender: sender})
+
+	msg := strings.Replace(testMessage, "To: Jane <jane@example.org>\r\n",
+		"To: Jane <jane@example.org>, Bob <bob@example.org>\r\nCc: Ann <ann@example.org>\r\n", 1)
+	err := smtp.SendMail(addr, nil, "app@example.com", []string{"ann@example.org"}, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := sender.emails[0]
+	if len(e.To) != 0 || len(e.Bcc) != 0 || e.CC["ann@example.org"] != "Ann" {
+		t.Errorf("Header recipients are being delivered to: %v %v %v", e.To, e.CC, e.Bcc)
+	}
+}
+
+func TestServerLineTooLong(t *testing.T) {
+
+	addr := startServer(t, &Server{Sender: &fakeSender{}})
+
+	c, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, _, err := c.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	c.W.WriteString("NOOP " + strings.Repeat("a", maxLineLength))
+	c.W.Flush()
+
+	if _, _, err := c.ReadResponse(250); err == nil || !strings.HasPrefix(err.Error(), "500") {
+		t.Errorf("Long lines are not being refused: %v", err)
+	}
+}
+
+func TestServerRecipientLimit(t *testing.T) {
+
+	addr := startServer(t, &Server{Sender: &fakeSender{}})
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Mail("john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxRecipients; i++ {
+		if err := c.Rcpt(fmt.Sprintf("user%d@example.org", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = c.Rcpt("one-too-many@example.org")
+	if err == nil || !strings.HasPrefix(err.Error(), "452") {
+		t.Errorf("Recipients are not being capped: %v", err)
+	}
+}
+
+func TestServerLimitersAreBounded(t *testing.T) {
+
+	s := &Server{Rate: 1000, Burst: 1}
+	for i := 0; i < maxLimiters+10; i++ {
+		s.allow(fmt.Sprint(i))
+	}
+	if len(s.limiters) > maxLimiters {
+		t.Errorf("Rate limiters are not being bounded: %d", len(s.limiters))
+	}
+}
+
+func TestServerAllowSenders(t *testing.T) {
+
+	sender := &fakeSender{}
+	addr := startServer(t, &Server{Sender: sender, AllowSenders: []string{"@example.com"}})
+
+	err := smtp.SendMail(addr, nil, "spam@example.net", []string{"jane@example.org"}, []byte(testMessage))
+	if err == nil || !strings.HasPrefix(err.Error(), "550") {
+		t.Errorf("Envelope senders are not being checked: %v", err)
+	}
+
+	forged := strings.Replace(testMessage, "app@example.com", "ceo@example.net", 1)
+	err = smtp.SendMail(addr, nil, "app@example.com", []string{"jane@example.org"}, []byte(forged))
+	if err == nil || !strings.HasPrefix(err.Error(), "550") {
+		t.Errorf("Header senders are not being checked: %v", err)
+	}
+
+	if len(sender.emails) != 0 {
+		t.Error("Disallowed senders are being forwarded.")
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+
+	sender := &fakeSender{}
+	addr := startServer(t, &Server{Sender: sender, Rate: 0.001, Burst: 1})
+
+	send := func() error {
+		return smtp.SendMail(addr, nil, "app@example.com", []string{"jane@example.org"}, []byte(testMessage))
+	}
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(); err == nil || !strings.HasPrefix(err.Error(), "451") {
+		t.Errorf("Clients over their rate are not being deferred: %v", err)
+	}
+}
+
+func TestServerSendFailure(t *testing.T) {
+
+	sender := &fakeSender{err: errors.New("api down")}
+	addr := startServer(t, &Server{Sender: sender})
+
+	err := smtp.SendMail(addr, nil, "app@example.com", []string{"jane@example.org"}, []byte(testMessage))
+	if err == nil || !strings.HasPrefix(err.Error(), "451") {
+		t.Errorf("API failures are not being reported as temporary: %v", err)
+	}
+}
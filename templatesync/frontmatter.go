@@ -0,0 +1,161 @@
+package templatesync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalTemplate is an email template as it is stored on disk:
+// an HTML file whose front-matter holds the template metadata.
+//
+//	---
+//	subject: Welcome aboard
+//	from: Sender Name <sender@example.net>
+//	reply-to: support@example.net
+//	status: active
+//	---
+//	<html>...</html>
+type LocalTemplate struct {
+	Name      string // file name without extension, used as the template name
+	Path      string
+	Subject   string
+	FromName  string
+	FromEmail string
+	ReplyTo   string
+	Status    int // 0 (inactive) or 1 (active)
+	HTML      string
+}
+
+const frontMatterDelim = "---"
+
+// ParseTemplate reads a single template from its front-matter and HTML body.
+func ParseTemplate(name string, data []byte) (LocalTemplate, error) {
+
+	t := LocalTemplate{Name: name, Status: 1}
+
+	rest := strings.TrimPrefix(string(data), "\ufeff")
+	line, rest := nextLine(rest)
+	if strings.TrimSpace(line) != frontMatterDelim {
+		err := fmt.Errorf("Template %q: missing front-matter", name)
+		return t, err
+	}
+
+	closed := false
+	for rest != "" {
+		line, rest = nextLine(rest)
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == frontMatterDelim {
+			closed = true
+			break
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			err := fmt.Errorf("Template %q: invalid front-matter line %q", name, line)
+			return t, err
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+
+		switch key {
+		case "subject":
+			t.Subject = value
+		case "from":
+			addr, err := mail.ParseAddress(value)
+			if err != nil {
+				err = fmt.Errorf("Template %q: invalid from address: %+v", name, err)
+				return t, err
+			}
+			t.FromName = addr.Name
+			t.FromEmail = addr.Address
+		case "reply-to", "reply_to", "replyto":
+			t.ReplyTo = value
+		case "status":
+			switch strings.ToLower(value) {
+			case "active", "1", "true":
+				t.Status = 1
+			case "inactive", "0", "false":
+				t.Status = 0
+			default:
+				err := fmt.Errorf("Template %q: unknown status %q", name, value)
+				return t, err
+			}
+		default:
+			err := fmt.Errorf("Template %q: unknown front-matter key %q", name, key)
+			return t, err
+		}
+	}
+	if !closed {
+		err := fmt.Errorf("Template %q: unterminated front-matter", name)
+		return t, err
+	}
+
+	t.HTML = strings.TrimLeft(rest, "\r\n")
+
+	if t.Subject == "" {
+		err := fmt.Errorf("Template %q: subject is mandatory", name)
+		return t, err
+	}
+	if t.FromEmail == "" {
+		err := fmt.Errorf("Template %q: from is mandatory", name)
+		return t, err
+	}
+
+	return t, nil
+}
+
+// LoadDir reads every *.html file in dir, sorted by name.
+func LoadDir(dir string) ([]LocalTemplate, error) {
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		err = fmt.Errorf("Could not list template directory: %+v", err)
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var templates []LocalTemplate
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			err = fmt.Errorf("Could not read template file: %+v", err)
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		t, err := ParseTemplate(name, data)
+		if err != nil {
+			return nil, err
+		}
+		t.Path = p
+
+		templates = append(templates, t)
+	}
+
+	if len(templates) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			err = fmt.Errorf("Could not open template directory: %+v", err)
+			return nil, err
+		}
+	}
+
+	return templates, nil
+}
+
+// nextLine splits s after its first line, dropping the line terminator.
+func nextLine(s string) (string, string) {
+	i := strings.IndexByte(s, '\n')
+	if i < 0 {
+		return strings.TrimSuffix(s, "\r"), ""
+	}
+	return strings.TrimSuffix(s[:i], "\r"), s[i+1:]
+}
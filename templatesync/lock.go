@@ -0,0 +1,62 @@
+package templatesync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Lock maps local template names to their SendInBlue template IDs.
+// It is persisted next to the templates so that renamed or re-created
+// remote templates are never confused with the ones managed here.
+type Lock map[string]int
+
+// ReadLock loads a lock file. A missing file yields an empty Lock.
+func ReadLock(path string) (Lock, error) {
+
+	lock := make(Lock)
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("Could not read lock file: %+v", err)
+		return lock, err
+	}
+
+	err = json.Unmarshal(b, &lock)
+	if err != nil {
+		err = fmt.Errorf("Could not decode lock file: %+v", err)
+		return lock, err
+	}
+
+	return lock, nil
+}
+
+// Write stores the lock file, replacing it atomically.
+func (l Lock) Write(path string) error {
+
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("Could not marshal JSON: %+v", err)
+		return err
+	}
+	b = append(b, '\n')
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		err = fmt.Errorf("Could not write lock file: %+v", err)
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		err = fmt.Errorf("Could not write lock file: %+v", err)
+		return err
+	}
+
+	return nil
+}
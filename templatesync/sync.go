@@ -0,0 +1,273 @@
+// Package templatesync keeps SendInBlue email templates in step with
+// a directory of HTML files carrying front-matter metadata.
+package templatesync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+// API is the subset of *sib.Client used to synchronise templates.
+type API interface {
+	ListTemplates(t *sib.TemplateList) (sib.TemplateListResponse, error)
+	GetTemplate(template_id int) (sib.CampaignResponse, error)
+	CreateTemplate(t *sib.Template) (sib.TemplateResponse, error)
+	UpdateTemplate(id int, t *sib.Template) error
+}
+
+// Action is what a Change does to a remote template.
+type Action int
+
+const (
+	Unchanged Action = iota
+	Create
+	Update
+	Deactivate
+	Forget // remote template is gone, drop it from the lock file
+)
+
+func (a Action) String() string {
+	switch a {
+	case Unchanged:
+		return "unchanged"
+	case Create:
+		return "create"
+	case Update:
+		return "update"
+	case Deactivate:
+		return "deactivate"
+	case Forget:
+		return "forget"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Change is a single planned step of a sync.
+type Change struct {
+	Action Action
+	Name   string
+	ID     int               // remote template ID, 0 for Create
+	Local  *LocalTemplate    // nil for Deactivate and Forget
+	Remote *sib.CampaignData // nil for Create and Forget
+	Fields []string          // fields that differ, for Update
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%-10s %s", c.Action, c.Name)
+	if c.ID != 0 {
+		s += fmt.Sprintf(" (id %d)", c.ID)
+	}
+	if len(c.Fields) > 0 {
+		s += ": " + strings.Join(c.Fields, ", ")
+	}
+	return s
+}
+
+// PageLimit is the page size used when listing remote templates.
+var PageLimit = 50
+
+// Plan diffs the local templates against the account and returns the
+// changes needed to bring the account in line, sorted by template name.
+// Templates are matched through the lock file first and by name second;
+// only templates recorded in the lock file are ever deactivated.
+func Plan(api API, local []LocalTemplate, lock Lock) ([]Change, error) {
+
+	remote, err := listRemote(api)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]int)
+	for id, d := range remote {
+		if _, ok := byName[d.Campaign_name]; !ok || id < byName[d.Campaign_name] {
+			byName[d.Campaign_name] = id
+		}
+	}
+
+	var changes []Change
+	seen := make(map[string]bool)
+
+	for i := range local {
+		l := &local[i]
+		seen[l.Name] = true
+
+		id, ok := lock[l.Name]
+		if !ok || remote[id] == nil {
+			id, ok = byName[l.Name]
+		}
+		if !ok {
+			changes = append(changes, Change{Action: Create, Name: l.Name, Local: l})
+			continue
+		}
+
+		r, err := details(api, id)
+		if err != nil {
+			return nil, err
+		}
+
+		c := Change{Action: Unchanged, Name: l.Name, ID: id, Local: l, Remote: r}
+		if c.Fields = diff(l, r); len(c.Fields) > 0 || lock[l.Name] != id {
+			c.Action = Update
+		}
+		changes = append(changes, c)
+	}
+
+	for name, id := range lock {
+		if seen[name] {
+			continue
+		}
+		if remote[id] == nil {
+			changes = append(changes, Change{Action: Forget, Name: name, ID: id})
+			continue
+		}
+		if remoteStatus(remote[id]) == 0 {
+			continue
+		}
+
+		r, err := details(api, id)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{Action: Deactivate, Name: name, ID: id, Remote: r})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+
+	return changes, nil
+}
+
+// Apply performs the planned changes and records new template IDs in lock.
+// It stops at the first failure; lock reflects every change applied so far.
+func Apply(api API, changes []Change, lock Lock) error {
+
+	for _, c := range changes {
+		switch c.Action {
+		case Create:
+			resp, err := api.CreateTemplate(toTemplate(c.Local))
+			if err != nil {
+				return fmt.Errorf("Could not create template %q: %+v", c.Name, err)
+			}
+			if resp.Code != "success" {
+				return fmt.Errorf("Could not create template %q: %s", c.Name, resp.Message)
+			}
+			lock[c.Name] = resp.Data.ID
+
+		case Update:
+			err := api.UpdateTemplate(c.ID, toTemplate(c.Local))
+			if err != nil {
+				return fmt.Errorf("Could not update template %q: %+v", c.Name, err)
+			}
+			lock[c.Name] = c.ID
+
+		case Deactivate:
+			t := &sib.Template{
+				From_name:     c.Remote.From_name,
+				Template_name: c.Remote.Campaign_name,
+				Html_content:  c.Remote.Html_content,
+				Subject:       c.Remote.Subject,
+				From_email:    c.Remote.From_email,
+				Reply_to:      c.Remote.Reply_to,
+				To_field:      c.Remote.To_field,
+				Status:        0,
+			}
+			err := api.UpdateTemplate(c.ID, t)
+			if err != nil {
+				return fmt.Errorf("Could not deactivate template %q: %+v", c.Name, err)
+			}
+
+		case Forget:
+			delete(lock, c.Name)
+		}
+	}
+
+	return nil
+}
+
+func toTemplate(l *LocalTemplate) *sib.Template {
+	return &sib.Template{
+		From_name:     l.FromName,
+		Template_name: l.Name,
+		Html_content:  l.HTML,
+		Subject:       l.Subject,
+		From_email:    l.FromEmail,
+		Reply_to:      l.ReplyTo,
+		Status:        l.Status,
+	}
+}
+
+func diff(l *LocalTemplate, r *sib.CampaignData) []string {
+
+	var fields []string
+
+	if l.Subject != r.Subject {
+		fields = append(fields, "subject")
+	}
+	if l.FromName != r.From_name || l.FromEmail != r.From_email {
+		fields = append(fields, "from")
+	}
+	if l.ReplyTo != r.Reply_to {
+		fields = append(fields, "reply-to")
+	}
+	if l.Status != remoteStatus(r) {
+		fields = append(fields, "status")
+	}
+	if strings.TrimSpace(l.HTML) != strings.TrimSpace(r.Html_content) {
+		fields = append(fields, "html")
+	}
+
+	return fields
+}
+
+func remoteStatus(r *sib.CampaignData) int {
+	switch strings.ToLower(r.Templ_status) {
+	case "1", "active", "true":
+		return 1
+	}
+	return 0
+}
+
+func listRemote(api API) (map[int]*sib.CampaignData, error) {
+
+	remote := make(map[int]*sib.CampaignData)
+
+	for page := 1; ; page++ {
+		resp, err := api.ListTemplates(&sib.TemplateList{
+			Type:       "template",
+			Page:       page,
+			Page_limit: PageLimit,
+		})
+		if err != nil {
+			err = fmt.Errorf("Could not list templates: %+v", err)
+			return nil, err
+		}
+
+		records := resp.Data.Campaign_records
+		for i := range records {
+			remote[records[i].ID] = &records[i]
+		}
+
+		if len(records) < PageLimit || len(remote) >= resp.Data.Total_campaign_records {
+			break
+		}
+	}
+
+	return remote, nil
+}
+
+func details(api API, id int) (*sib.CampaignData, error) {
+
+	resp, err := api.GetTemplate(id)
+	if err != nil {
+		err = fmt.Errorf("Could not get template %d: %+v", id, err)
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		err = fmt.Errorf("Could not get template %d: %s", id, resp.Message)
+		return nil, err
+	}
+
+	return &resp.Data[0], nil
+}
@@ -0,0 +1,230 @@
+package templatesync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type fakeAPI struct {
+	templates map[int]*sib.CampaignData
+	nextID    int
+	updates   []int
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{templates: make(map[int]*sib.CampaignData), nextID: 1}
+}
+
+func (f *fakeAPI) ListTemplates(t *sib.TemplateList) (sib.TemplateListResponse, error) {
+	resp := sib.TemplateListResponse{Code: "success"}
+	for _, d := range f.templates {
+		c := *d
+		c.Html_content = ""
+		resp.Data.Campaign_records = append(resp.Data.Campaign_records, c)
+	}
+	resp.Data.Total_campaign_records = len(f.templates)
+	return resp, nil
+}
+
+func (f *fakeAPI) GetTemplate(id int) (sib.CampaignResponse, error) {
+	d, ok := f.templates[id]
+	if !ok {
+		return sib.CampaignResponse{Code: "failure", Message: "not found"}, nil
+	}
+	return sib.CampaignResponse{Code: "success", Data: []sib.CampaignData{*d}}, nil
+}
+
+func (f *fakeAPI) CreateTemplate(t *sib.Template) (sib.TemplateResponse, error) {
+	id := f.nextID
+	f.nextID++
+	f.store(id, t)
+	return sib.TemplateResponse{Code: "success", Data: sib.TemplateData{ID: id}}, nil
+}
+
+func (f *fakeAPI) UpdateTemplate(id int, t *sib.Template) error {
+	if _, ok := f.templates[id]; !ok {
+		return fmt.Errorf("no template %d", id)
+	}
+	f.updates = append(f.updates, id)
+	f.store(id, t)
+	return nil
+}
+
+func (f *fakeAPI) store(id int, t *sib.Template) {
+	status := "Inactive"
+	if t.Status == 1 {
+		status = "Active"
+	}
+	f.templates[id] = &sib.CampaignData{
+		ID:            id,
+		Campaign_name: t.Template_name,
+		Subject:       t.Subject,
+		Html_content:  t.Html_content,
+		Templ_status:  status,
+		From_name:     t.From_name,
+		From_email:    t.From_email,
+		Reply_to:      t.Reply_to,
+	}
+}
+
+const welcome = `---
+subject: Welcome aboard
+from: Sender Name <sender@example.net>
+reply-to: support@example.net
+status: active
+---
+<p>Hello %FIRSTNAME%</p>
+`
+
+func TestParseTemplate(t *testing.T) {
+
+	tmpl, err := ParseTemplate("welcome", []byte(welcome))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tmpl.Subject != "Welcome aboard" {
+		t.Errorf("Subject is not being parsed: %q", tmpl.Subject)
+	}
+	if tmpl.FromName != "Sender Name" || tmpl.FromEmail != "sender@example.net" {
+		t.Errorf("From is not being parsed: %q %q", tmpl.FromName, tmpl.FromEmail)
+	}
+	if tmpl.ReplyTo != "support@example.net" {
+		t.Errorf("Reply-to is not being parsed: %q", tmpl.ReplyTo)
+	}
+	if tmpl.Status != 1 {
+		t.Error("Status is not being parsed.")
+	}
+	if tmpl.HTML != "<p>Hello %FIRSTNAME%</p>\n" {
+		t.Errorf("HTML body is not being split from the front-matter: %q", tmpl.HTML)
+	}
+
+	if _, err := ParseTemplate("bad", []byte("<p>no front-matter</p>")); err == nil {
+		t.Error("Expected a template without front-matter to fail.")
+	}
+	if _, err := ParseTemplate("bad", []byte("---\nsubject: x\n")); err == nil {
+		t.Error("Expected unterminated front-matter to fail.")
+	}
+	if _, err := ParseTemplate("bad", []byte("---\nfrom: a@example.net\n---\n")); err == nil {
+		t.Error("Expected a template without subject to fail.")
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+
+	api := newFakeAPI()
+	lock := make(Lock)
+
+	w, _ := ParseTemplate("welcome", []byte(welcome))
+	local := []LocalTemplate{w}
+
+	changes, err := Plan(api, local, lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != Create {
+		t.Fatalf("Expected a single create, got %v", changes)
+	}
+
+	if err := Apply(api, changes, lock); err != nil {
+		t.Fatal(err)
+	}
+	if lock["welcome"] != 1 {
+		t.Fatalf("Created template ID is not being recorded in the lock: %v", lock)
+	}
+
+	changes, _ = Plan(api, local, lock)
+	if len(changes) != 1 || changes[0].Action != Unchanged {
+		t.Fatalf("Expected no changes after apply, got %v", changes)
+	}
+
+	local[0].Subject = "Welcome!"
+	changes, _ = Plan(api, local, lock)
+	if len(changes) != 1 || changes[0].Action != Update || changes[0].Fields[0] != "subject" {
+		t.Fatalf("Expected a subject update, got %v", changes)
+	}
+	Apply(api, changes, lock)
+	if api.templates[1].Subject != "Welcome!" {
+		t.Error("Template is not being updated.")
+	}
+
+	changes, _ = Plan(api, nil, lock)
+	if len(changes) != 1 || changes[0].Action != Deactivate {
+		t.Fatalf("Expected a deactivation, got %v", changes)
+	}
+	Apply(api, changes, lock)
+	if api.templates[1].Templ_status != "Inactive" {
+		t.Error("Template is not being deactivated.")
+	}
+
+	changes, _ = Plan(api, nil, lock)
+	if len(changes) != 0 {
+		t.Errorf("Expected an inactive template to be left alone, got %v", changes)
+	}
+
+	delete(api.templates, 1)
+	changes, _ = Plan(api, nil, lock)
+	if len(changes) != 1 || changes[0].Action != Forget {
+		t.Fatalf("Expected a missing template to be forgotten, got %v", changes)
+	}
+	Apply(api, changes, lock)
+	if _, ok := lock["welcome"]; ok {
+		t.Error("Forgotten template is still in the lock.")
+	}
+}
+
+func TestPlanAdoptsByName(t *testing.T) {
+
+	api := newFakeAPI()
+	w, _ := ParseTemplate("welcome", []byte(welcome))
+	api.CreateTemplate(toTemplate(&w))
+
+	lock := make(Lock)
+	changes, err := Plan(api, []LocalTemplate{w}, lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != Update || changes[0].ID != 1 {
+		t.Fatalf("Expected the existing template to be adopted, got %v", changes)
+	}
+}
+
+func TestLoadDirAndLock(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "templatesync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "welcome.html"), []byte(welcome), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644)
+
+	templates, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].Name != "welcome" {
+		t.Fatalf("Expected one template named welcome, got %v", templates)
+	}
+
+	lockPath := filepath.Join(dir, "sib-templates.lock")
+	lock, err := ReadLock(lockPath)
+	if err != nil || len(lock) != 0 {
+		t.Fatalf("Expected a missing lock file to be empty: %v %v", lock, err)
+	}
+
+	lock["welcome"] = 42
+	if err := lock.Write(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = ReadLock(lockPath)
+	if err != nil || lock["welcome"] != 42 {
+		t.Errorf("Lock file is not being round-tripped: %v %v", lock, err)
+	}
+}
@@ -0,0 +1,64 @@
+package tenant
+
+import (
+	"sync"
+	"time"
+)
+
+// quota counts sends per UTC day.
+type quota struct {
+	mu    sync.Mutex
+	limit int
+	day   string
+	used  int
+}
+
+// take reserves a send for today, reporting false when the quota is used up.
+func (q *quota) take(now time.Time) bool {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reset(now)
+	if q.limit > 0 && q.used >= q.limit {
+		return false
+	}
+	q.used++
+	return true
+}
+
+// refund gives back a send taken at taken, unless the day has changed.
+func (q *quota) refund(taken time.Time) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if taken.UTC().Format("2006-01-02") == q.day && q.used > 0 {
+		q.used--
+	}
+}
+
+func (q *quota) setLimit(limit int) {
+	q.mu.Lock()
+	q.limit = limit
+	q.mu.Unlock()
+}
+
+func (q *quota) max() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit
+}
+
+func (q *quota) usage(now time.Time) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reset(now)
+	return q.used
+}
+
+func (q *quota) reset(now time.Time) {
+	if day := now.UTC().Format("2006-01-02"); day != q.day {
+		q.day, q.used = day, 0
+	}
+}
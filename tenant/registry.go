@@ -0,0 +1,267 @@
+// Package tenant manages one sib.Client per tenant for services that send
+// on behalf of many SendInBlue accounts, with per-tenant rate limits and
+// daily quotas.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JKhawaja/sendinblue"
+	"github.com/JKhawaja/sendinblue/internal/ratelimit"
+)
+
+// Config describes a tenant's account and limits.
+type Config struct {
+	APIKey  string       // the tenant's SendInBlue API key
+	Options []sib.Option // extra client options, e.g. sib.WithCredentials
+
+	Rate       float64 // sends per second, 0 for no limit
+	Burst      int     // sends allowed at once before Rate applies (default 1)
+	DailyQuota int     // sends per UTC day, 0 for no limit
+}
+
+// A Lookup returns the Config of a tenant. It is called the first time a
+// tenant is used, and again after the tenant has been removed.
+type Lookup func(tenantID string) (Config, error)
+
+// QuotaError is returned when a tenant has used up its daily quota.
+type QuotaError struct {
+	TenantID string
+	Quota    int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("Tenant %s has used its daily quota of %d sends", e.TenantID, e.Quota)
+}
+
+type tenant struct {
+	client  *sib.Client
+	limiter *ratelimit.Limiter
+	quota   *quota
+	wg      sync.WaitGroup // sends in flight
+}
+
+// Registry lazily builds and caches a sib.Client per tenant and routes
+// sends through the tenant's limits.
+type Registry struct {
+	lookup Lookup
+
+	mu      sync.Mutex
+	tenants map[string]*tenant
+	quotas  map[string]*quota // kept across Remove so usage is not reset
+}
+
+// NewRegistry returns a Registry that configures tenants with lookup.
+func NewRegistry(lookup Lookup) *Registry {
+	return &Registry{
+		lookup:  lookup,
+		tenants: make(map[string]*tenant),
+		quotas:  make(map[string]*quota),
+	}
+}
+
+// Client returns the tenant's client, building it on first use. Sends
+// made directly on it bypass the tenant's limits.
+func (r *Registry) Client(tenantID string) (*sib.Client, error) {
+
+	t, err := r.get(tenantID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.client, nil
+}
+
+// Usage returns the number of sends the tenant made today.
+func (r *Registry) Usage(tenantID string) int {
+
+	r.mu.Lock()
+	q := r.quotas[tenantID]
+	r.mu.Unlock()
+
+	if q == nil {
+		return 0
+	}
+	return q.usage(time.Now())
+}
+
+// SendEmail sends a transactional email from the tenant's account.
+func (r *Registry) SendEmail(ctx context.Context, tenantID string, e *sib.Email) (sib.EmailResponse, error) {
+
+	t, taken, err := r.acquire(ctx, tenantID)
+	if err != nil {
+		return sib.EmailResponse{}, err
+	}
+	defer t.wg.Done()
+
+	resp, err := t.client.SendEmail(e)
+	t.settle(taken, delivered(resp.Code, err))
+
+	return resp, err
+}
+
+// SendTemplateEmail sends a template email from the tenant's account.
+func (r *Registry) SendTemplateEmail(ctx context.Context, tenantID string, id int, to []string, e *sib.EmailOptions) (sib.EmailResponse, error) {
+
+	t, taken, err := r.acquire(ctx, tenantID)
+	if err != nil {
+		return sib.EmailResponse{}, err
+	}
+	defer t.wg.Done()
+
+	resp, err := t.client.SendTemplateEmail(id, to, e)
+	t.settle(taken, delivered(resp.Code, err))
+
+	return resp, err
+}
+
+// SendSMS sends an SMS from the tenant's account.
+func (r *Registry) SendSMS(ctx context.Context, tenantID string, s *sib.SMSRequest) (sib.SMSResponse, error) {
+
+	t, taken, err := r.acquire(ctx, tenantID)
+	if err != nil {
+		return sib.SMSResponse{}, err
+	}
+	defer t.wg.Done()
+
+	resp, err := t.client.SendSMS(s)
+	t.settle(taken, delivered(resp.Code, err))
+
+	return resp, err
+}
+
+// Remove drops a tenant after its sends in flight have finished and
+// closes its idle connections. A later send configures it afresh, which
+// picks up a changed key or limits; the day's quota usage is kept.
+func (r *Registry) Remove(tenantID string) {
+
+	r.mu.Lock()
+	t := r.tenants[tenantID]
+	delete(r.tenants, tenantID)
+	r.mu.Unlock()
+
+	if t != nil {
+		t.close()
+	}
+}
+
+// Close removes every tenant.
+func (r *Registry) Close() {
+
+	r.mu.Lock()
+	tenants := r.tenants
+	r.tenants = make(map[string]*tenant)
+	r.mu.Unlock()
+
+	for _, t := range tenants {
+		t.close()
+	}
+}
+
+// acquire returns the tenant once its rate limit and quota allow a send,
+// and the time the quota was charged. The caller must call t.wg.Done when
+// the send has finished.
+func (r *Registry) acquire(ctx context.Context, tenantID string) (*tenant, time.Time, error) {
+
+	t, err := r.get(tenantID, true)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			t.wg.Done()
+			return nil, time.Time{}, err
+		}
+	}
+
+	now := time.Now()
+	if !t.quota.take(now) {
+		t.wg.Done()
+		return nil, time.Time{}, &QuotaError{TenantID: tenantID, Quota: t.quota.max()}
+	}
+
+	return t, now, nil
+}
+
+// delivered reports whether a send was accepted by the API. An
+// ArchiveError comes after the email was sent.
+func delivered(code string, err error) bool {
+	var archiveErr *sib.ArchiveError
+	return (err == nil && code == "success") || errors.As(err, &archiveErr)
+}
+
+// settle gives back the quota charged at taken when the send failed, so
+// that API errors do not use up the tenant's allowance.
+func (t *tenant) settle(taken time.Time, sent bool) {
+	if !sent {
+		t.quota.refund(taken)
+	}
+}
+
+// get returns the tenant, building it on first use. The lookup runs
+// outside the lock so that a slow lookup does not hold up other tenants.
+// With inFlight, a send is registered under the lock so that Remove waits
+// for it.
+func (r *Registry) get(tenantID string, inFlight bool) (*tenant, error) {
+
+	r.mu.Lock()
+	t, ok := r.tenants[tenantID]
+	if ok {
+		if inFlight {
+			t.wg.Add(1)
+		}
+		r.mu.Unlock()
+		return t, nil
+	}
+	r.mu.Unlock()
+
+	cfg, err := r.lookup(tenantID)
+	if err != nil {
+		err = fmt.Errorf("Could not configure tenant %s: %+v", tenantID, err)
+		return nil, err
+	}
+
+	client, err := sib.NewClient(cfg.APIKey, cfg.Options...)
+	if err != nil {
+		err = fmt.Errorf("Could not configure tenant %s: %+v", tenantID, err)
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Another caller may have configured the tenant in the meantime.
+	t, ok = r.tenants[tenantID]
+	if !ok {
+		q := r.quotas[tenantID]
+		if q == nil {
+			q = &quota{}
+			r.quotas[tenantID] = q
+		}
+		q.setLimit(cfg.DailyQuota)
+
+		t = &tenant{client: client, quota: q}
+		if cfg.Rate > 0 {
+			t.limiter = ratelimit.New(cfg.Rate, cfg.Burst)
+		}
+		r.tenants[tenantID] = t
+	} else {
+		client.Client.CloseIdleConnections()
+	}
+
+	if inFlight {
+		t.wg.Add(1)
+	}
+
+	return t, nil
+}
+
+func (t *tenant) close() {
+	t.wg.Wait()
+	t.client.Client.CloseIdleConnections()
+}
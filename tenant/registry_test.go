@@ -0,0 +1,244 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JKhawaja/sendinblue"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fakeAPI answers every request in-process and records the keys used.
+type fakeAPI struct {
+	mu    sync.Mutex
+	keys  []string
+	delay time.Duration
+	fail  bool
+}
+
+func (f *fakeAPI) option() sib.Option {
+	return func(c *sib.Client) {
+		c.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			time.Sleep(f.delay)
+			f.mu.Lock()
+			f.keys = append(f.keys, r.Header.Get("api-key"))
+			fail := f.fail
+			f.mu.Unlock()
+			if fail {
+				return &http.Response{
+					StatusCode: 400,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"code":"failure"}`)),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader(`{"code":"success"}`)),
+			}, nil
+		})
+	}
+}
+
+func (f *fakeAPI) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.keys...)
+}
+
+func TestRegistryRoutesByTenant(t *testing.T) {
+
+	api := &fakeAPI{}
+	lookups := 0
+	r := NewRegistry(func(id string) (Config, error) {
+		lookups++
+		if id == "unknown" {
+			return Config{}, fmt.Errorf("no such tenant")
+		}
+		return Config{APIKey: "key-" + id, Options: []sib.Option{api.option()}}, nil
+	})
+	defer r.Close()
+
+	ctx := context.Background()
+	r.SendEmail(ctx, "a", sib.NewEmail())
+	r.SendSMS(ctx, "b", &sib.SMSRequest{})
+	r.SendTemplateEmail(ctx, "a", 1, []string{"x@example.net"}, nil)
+
+	if got := strings.Join(api.sent(), ","); got != "key-a,key-b,key-a" {
+		t.Errorf("Sends are not being routed by tenant: %s", got)
+	}
+	if lookups != 2 {
+		t.Errorf("Expected clients to be cached, looked up %d times", lookups)
+	}
+	if r.Usage("a") != 2 {
+		t.Errorf("Expected 2 sends for tenant a, got %d", r.Usage("a"))
+	}
+
+	if _, err := r.SendSMS(ctx, "unknown", &sib.SMSRequest{}); err == nil {
+		t.Error("Expected an unknown tenant to fail.")
+	}
+}
+
+func TestRegistryQuota(t *testing.T) {
+
+	api := &fakeAPI{}
+	r := NewRegistry(func(id string) (Config, error) {
+		return Config{APIKey: "k", DailyQuota: 2, Options: []sib.Option{api.option()}}, nil
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := r.SendSMS(ctx, "a", &sib.SMSRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := r.SendSMS(ctx, "a", &sib.SMSRequest{})
+	if _, ok := err.(*QuotaError); !ok {
+		t.Errorf("Expected a QuotaError, got %v", err)
+	}
+	if len(api.sent()) != 2 {
+		t.Error("A send over quota reached the API.")
+	}
+
+	if _, err := r.SendSMS(ctx, "b", &sib.SMSRequest{}); err != nil {
+		t.Errorf("Quotas are not per tenant: %v", err)
+	}
+}
+
+func TestRegistryQuotaRefund(t *testing.T) {
+
+	api := &fakeAPI{fail: true}
+	r := NewRegistry(func(id string) (Config, error) {
+		return Config{APIKey: "k", DailyQuota: 1, Options: []sib.Option{api.option()}}, nil
+	})
+
+	ctx := context.Background()
+	r.SendSMS(ctx, "a", &sib.SMSRequest{})
+	if r.Usage("a") != 0 {
+		t.Error("A failed send is not being refunded.")
+	}
+
+	api.mu.Lock()
+	api.fail = false
+	api.mu.Unlock()
+
+	if _, err := r.SendSMS(ctx, "a", &sib.SMSRequest{}); err != nil {
+		t.Errorf("A failed send used up the quota: %v", err)
+	}
+	if r.Usage("a") != 1 {
+		t.Errorf("Expected 1 send for tenant a, got %d", r.Usage("a"))
+	}
+}
+
+func TestRegistryQuotaSurvivesRemove(t *testing.T) {
+
+	api := &fakeAPI{}
+	r := NewRegistry(func(id string) (Config, error) {
+		return Config{APIKey: "k", DailyQuota: 1, Options: []sib.Option{api.option()}}, nil
+	})
+
+	ctx := context.Background()
+	if _, err := r.SendSMS(ctx, "a", &sib.SMSRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Remove("a")
+
+	_, err := r.SendSMS(ctx, "a", &sib.SMSRequest{})
+	if _, ok := err.(*QuotaError); !ok {
+		t.Errorf("Quota usage is being reset by Remove: %v", err)
+	}
+	if r.Usage("a") != 1 {
+		t.Errorf("Expected 1 send for tenant a, got %d", r.Usage("a"))
+	}
+}
+
+func TestRegistryLookupOutsideLock(t *testing.T) {
+
+	api := &fakeAPI{}
+	release := make(chan struct{})
+	r := NewRegistry(func(id string) (Config, error) {
+		if id == "slow" {
+			<-release
+		}
+		return Config{APIKey: "key-" + id, Options: []sib.Option{api.option()}}, nil
+	})
+	defer r.Close()
+
+	done := make(chan struct{})
+	go func() {
+		r.Client("slow")
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := r.SendSMS(ctx, "fast", &sib.SMSRequest{}); err != nil {
+		t.Errorf("A slow lookup is blocking other tenants: %v", err)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestRegistryRateLimit(t *testing.T) {
+
+	api := &fakeAPI{}
+	r := NewRegistry(func(id string) (Config, error) {
+		return Config{APIKey: "k", Rate: 50, Burst: 1, Options: []sib.Option{api.option()}}, nil
+	})
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		r.SendSMS(context.Background(), "a", &sib.SMSRequest{})
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected 6 sends at 50/s to take at least 100ms, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.SendSMS(context.Background(), "a", &sib.SMSRequest{})
+	if _, err := r.SendSMS(ctx, "a", &sib.SMSRequest{}); err != context.Canceled {
+		t.Errorf("Expected a rate-limited send to honour its context, got %v", err)
+	}
+}
+
+func TestRegistryRemoveWaitsForSends(t *testing.T) {
+
+	api := &fakeAPI{delay: 50 * time.Millisecond}
+	version := 0
+	r := NewRegistry(func(id string) (Config, error) {
+		version++
+		return Config{APIKey: fmt.Sprintf("v%d", version), Options: []sib.Option{api.option()}}, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		r.SendSMS(context.Background(), "a", &sib.SMSRequest{})
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	r.Remove("a")
+	select {
+	case <-done:
+	default:
+		t.Error("Remove returned before the send in flight finished.")
+	}
+
+	r.SendSMS(context.Background(), "a", &sib.SMSRequest{})
+	if got := strings.Join(api.sent(), ","); got != "v1,v2" {
+		t.Errorf("Expected a removed tenant to be configured afresh, got %s", got)
+	}
+}